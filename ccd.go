@@ -0,0 +1,167 @@
+package megasd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bodgit/megasd/cd"
+	"github.com/bodgit/megasd/cue"
+)
+
+// isCCD reports whether the file is a CloneCD control file
+func isCCD(file string) bool {
+	return hasExt(file, ".ccd")
+}
+
+// ccdModes maps the MODE of a CloneCD track to the equivalent cue sheet mode
+var ccdModes = map[string]string{
+	"0": cue.ModeAudio,
+	"1": cue.Mode1Raw,
+	"2": cue.Mode2Raw,
+}
+
+// parseCCD parses the [TRACK n] sections of a CloneCD control file. Each
+// track's indexes are logical block addresses, which are also the sector
+// offsets within the image as it starts at the first track's INDEX 01
+func parseCCD(file string) ([]*cue.Track, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var tracks []*cue.Track
+	var track *cue.Track
+
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(strings.TrimPrefix(s.Text(), "\ufeff"))
+		if text == "" || strings.HasPrefix(text, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			track = nil
+			fields := strings.Fields(strings.Trim(text, "[]"))
+			if len(fields) != 2 || !strings.EqualFold(fields[0], "TRACK") {
+				continue
+			}
+			n, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("ccd: line %d: invalid track number \"%s\"", line, fields[1])
+			}
+			track = &cue.Track{Number: n, Line: line}
+			tracks = append(tracks, track)
+			continue
+		}
+
+		if track == nil {
+			continue
+		}
+
+		kv := strings.SplitN(text, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := strings.ToUpper(strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1])
+
+		switch {
+		case key == "MODE":
+			mode, ok := ccdModes[value]
+			if !ok {
+				return nil, fmt.Errorf("ccd: line %d: unsupported track mode \"%s\"", line, value)
+			}
+			track.Mode = mode
+		case strings.HasPrefix(key, "INDEX"):
+			n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(key, "INDEX")))
+			if err != nil {
+				return nil, fmt.Errorf("ccd: line %d: invalid index \"%s\"", line, key)
+			}
+			frame, err := strconv.Atoi(value)
+			if err != nil || frame < 0 {
+				return nil, fmt.Errorf("ccd: line %d: invalid index position \"%s\"", line, value)
+			}
+			track.Indexes = append(track.Indexes, cue.Index{Number: n, Frame: frame})
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("ccd: no tracks in \"%s\"", file)
+	}
+
+	sort.Slice(tracks, func(i, j int) bool { return tracks[i].Number < tracks[j].Number })
+	for _, t := range tracks {
+		if t.Mode == "" {
+			return nil, fmt.Errorf("ccd: track %d has no mode", t.Number)
+		}
+		if len(t.Indexes) == 0 {
+			return nil, fmt.Errorf("ccd: track %d has no indexes", t.Number)
+		}
+		sort.Slice(t.Indexes, func(i, j int) bool { return t.Indexes[i].Number < t.Indexes[j].Number })
+	}
+
+	return tracks, nil
+}
+
+// ccdImage returns the image alongside the CloneCD control file, which has
+// the same name with an .img extension in either case
+func ccdImage(file string) (string, error) {
+	base := strings.TrimSuffix(file, filepath.Ext(file))
+	for _, ext := range []string{".img", ".IMG"} {
+		if _, err := os.Stat(base + ext); err == nil {
+			return base + ext, nil
+		}
+	}
+	return "", fmt.Errorf("no image found for \"%s\"", file)
+}
+
+// ccdExtents returns where every track of a CloneCD image is stored. The
+// subchannel data is kept in a separate .sub file so is never read
+func ccdExtents(file string) ([]trackExtent, error) {
+	tracks, err := parseCCD(file)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := ccdImage(file)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(img)
+	if err != nil {
+		return nil, err
+	}
+	end := info.Size() / cd.SectorSize
+
+	extents := make([]trackExtent, 0, len(tracks))
+	for i, t := range tracks {
+		first := int64(firstIndex(t))
+		last := end
+		if i+1 < len(tracks) {
+			last = int64(firstIndex(tracks[i+1]))
+		}
+		if last > end || last < first {
+			return nil, fmt.Errorf("track %d extends beyond \"%s\"", t.Number, img)
+		}
+
+		extents = append(extents, trackExtent{
+			file:   img,
+			kind:   "BINARY",
+			track:  t,
+			offset: first * cd.SectorSize,
+			length: (last - first) * cd.SectorSize,
+			lba:    int(first),
+		})
+	}
+
+	return extents, nil
+}
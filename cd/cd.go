@@ -0,0 +1,153 @@
+/*
+Package cd implements the layout of raw 2352 byte Mode 1 CD-ROM sectors,
+including the EDC and ECC used to detect and correct errors.
+
+Cooked 2048 byte sectors, such as those in an ISO image, can be expanded back
+into raw sectors so that they hash the same as a raw rip.
+*/
+package cd
+
+// Sizes of a raw Mode 1 sector and its parts
+const (
+	SectorSize = 2352
+	DataSize   = 2048
+	HeaderSize = 16
+
+	edcOffset = 0x810
+	pOffset   = 0x81c
+	qOffset   = 0x8c8
+
+	// The first sector of a disc is at 00:02:00
+	leadIn = 150
+
+	framesPerSecond = 75
+)
+
+// SyncPattern starts every raw data sector
+var SyncPattern = [12]byte{0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00}
+
+var (
+	eccF   [256]byte
+	eccB   [256]byte
+	edcLUT [256]uint32
+)
+
+func init() {
+	for i := 0; i < 256; i++ {
+		j := i << 1
+		if i&0x80 != 0 {
+			j ^= 0x11d
+		}
+		eccF[i] = byte(j)
+		eccB[i^j] = byte(i)
+
+		edc := uint32(i)
+		for k := 0; k < 8; k++ {
+			if edc&1 != 0 {
+				edc = edc>>1 ^ 0xd8018001
+			} else {
+				edc >>= 1
+			}
+		}
+		edcLUT[i] = edc
+	}
+}
+
+func bcd(n int) byte {
+	return byte(n/10<<4 | n%10)
+}
+
+func unbcd(b byte) int {
+	return int(b>>4)*10 + int(b&0x0f)
+}
+
+// MSF returns the BCD encoded minute, second and frame address of the
+// given logical block address as stored in a sector header
+func MSF(lba int) [3]byte {
+	lba += leadIn
+	return [3]byte{
+		bcd(lba / framesPerSecond / 60),
+		bcd(lba / framesPerSecond % 60),
+		bcd(lba % framesPerSecond),
+	}
+}
+
+// LBA returns the logical block address of a BCD encoded minute, second and
+// frame address
+func LBA(msf [3]byte) int {
+	return (unbcd(msf[0])*60+unbcd(msf[1]))*framesPerSecond + unbcd(msf[2]) - leadIn
+}
+
+// EDC computes the error detection code over b
+func EDC(b []byte) uint32 {
+	var edc uint32
+	for _, c := range b {
+		edc = edc>>8 ^ edcLUT[byte(edc)^c]
+	}
+	return edc
+}
+
+// computeECC computes one set of Reed-Solomon parity bytes, either P or Q,
+// over src into dst
+func computeECC(src []byte, majorCount, minorCount, majorMult, minorInc int, dst []byte) {
+	size := majorCount * minorCount
+	for major := 0; major < majorCount; major++ {
+		index := (major>>1)*majorMult + (major & 1)
+		var a, b byte
+		for minor := 0; minor < minorCount; minor++ {
+			t := src[index]
+			index += minorInc
+			if index >= size {
+				index -= size
+			}
+			a ^= t
+			b ^= t
+			a = eccF[a]
+		}
+		a = eccB[eccF[a]^b]
+		dst[major] = a
+		dst[major+majorCount] = a ^ b
+	}
+}
+
+// eccP computes the P parity of the sector into dst
+func eccP(sector, dst []byte) {
+	computeECC(sector[0xc:], 86, 24, 2, 86, dst)
+}
+
+// eccQ computes the Q parity of the sector into dst. The P parity must
+// already be present as it is covered by Q
+func eccQ(sector, dst []byte) {
+	computeECC(sector[0xc:], 52, 43, 86, 88, dst)
+}
+
+// Encode builds the sync pattern, header, EDC and ECC of the raw Mode 1
+// sector at the given logical block address around the 2048 bytes of user
+// data already at sector[HeaderSize:]
+func Encode(sector []byte, lba int) {
+	copy(sector, SyncPattern[:])
+	msf := MSF(lba)
+	copy(sector[12:], msf[:])
+	sector[15] = 1
+
+	edc := EDC(sector[:edcOffset])
+	sector[edcOffset] = byte(edc)
+	sector[edcOffset+1] = byte(edc >> 8)
+	sector[edcOffset+2] = byte(edc >> 16)
+	sector[edcOffset+3] = byte(edc >> 24)
+	for i := edcOffset + 4; i < pOffset; i++ {
+		sector[i] = 0
+	}
+
+	eccP(sector, sector[pOffset:qOffset])
+	eccQ(sector, sector[qOffset:SectorSize])
+}
+
+// Expand returns the raw Mode 1 sector at the given logical block address
+// for the 2048 bytes of user data in data
+func Expand(data []byte, lba int) []byte {
+	sector := make([]byte, SectorSize)
+	copy(sector[HeaderSize:], data[:DataSize])
+	Encode(sector, lba)
+	return sector
+}
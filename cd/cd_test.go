@@ -0,0 +1,81 @@
+package cd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// syndromes returns the two Reed-Solomon syndromes of a codeword, which are
+// both zero if the codeword is valid
+func syndromes(c []byte) (byte, byte) {
+	var s0, s1 byte
+	for _, b := range c {
+		s0 ^= b
+		s1 = eccF[s1] ^ b
+	}
+	return s0, s1
+}
+
+func TestEncode(t *testing.T) {
+	data := make([]byte, DataSize)
+	for i := range data {
+		data[i] = byte(i * 13)
+	}
+	sector := Expand(data, 16)
+
+	assert.Equal(t, SyncPattern[:], sector[:12])
+	assert.Equal(t, []byte{0x00, 0x02, 0x16, 0x01}, sector[12:16])
+	assert.Equal(t, 16, LBA([3]byte{0x00, 0x02, 0x16}))
+	assert.Equal(t, data, sector[HeaderSize:HeaderSize+DataSize])
+
+	// Every P codeword is a column of 24 bytes followed by its parity
+	for major := 0; major < 86; major++ {
+		var c []byte
+		for minor := 0; minor < 24; minor++ {
+			c = append(c, sector[0xc+(major>>1)*2+major&1+minor*86])
+		}
+		c = append(c, sector[pOffset+major], sector[pOffset+major+86])
+		s0, s1 := syndromes(c)
+		assert.Equal(t, byte(0), s0)
+		assert.Equal(t, byte(0), s1)
+	}
+
+	// Every Q codeword is a diagonal of 43 bytes followed by its parity
+	for major := 0; major < 52; major++ {
+		var c []byte
+		index := (major>>1)*86 + major&1
+		for minor := 0; minor < 43; minor++ {
+			c = append(c, sector[0xc+index])
+			index = (index + 88) % (52 * 43)
+		}
+		c = append(c, sector[qOffset+major], sector[qOffset+major+52])
+		s0, s1 := syndromes(c)
+		assert.Equal(t, byte(0), s0)
+		assert.Equal(t, byte(0), s1)
+	}
+}
+
+func TestCheckAndRepair(t *testing.T) {
+	data := make([]byte, DataSize)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	good := Expand(data, 1000)
+	assert.Equal(t, Error(0), Check(good, 1000))
+	assert.Equal(t, ErrAddress, Check(good, 1001))
+
+	for _, offset := range []int{0x5, 0xe, 0x100, 0x80f, 0x812, 0x850, 0x900} {
+		sector := append([]byte(nil), good...)
+		sector[offset] ^= 0x5a
+		assert.NotEqual(t, Error(0), Check(sector, 1000), offset)
+		assert.True(t, Repair(sector, 1000), offset)
+		assert.Equal(t, good, sector, offset)
+	}
+
+	// Two damaged bytes can't be repaired
+	sector := append([]byte(nil), good...)
+	sector[0x100] ^= 0x01
+	sector[0x400] ^= 0x02
+	assert.False(t, Repair(sector, 1000))
+}
@@ -0,0 +1,161 @@
+package cd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+)
+
+// Error describes what is wrong with a raw Mode 1 sector
+type Error int
+
+// Each problem found by Check
+const (
+	ErrSync Error = 1 << iota
+	ErrAddress
+	ErrMode
+	ErrEDC
+	ErrECCP
+	ErrECCQ
+)
+
+var errorNames = []struct {
+	e    Error
+	name string
+}{
+	{ErrSync, "sync pattern"},
+	{ErrAddress, "address"},
+	{ErrMode, "mode"},
+	{ErrEDC, "EDC"},
+	{ErrECCP, "ECC P parity"},
+	{ErrECCQ, "ECC Q parity"},
+}
+
+func (e Error) String() string {
+	var names []string
+	for _, n := range errorNames {
+		if e&n.e != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// Check verifies the raw Mode 1 sector expected at the given logical block
+// address and returns any problems found, or zero if there are none
+func Check(sector []byte, lba int) Error {
+	var e Error
+
+	if !bytes.Equal(sector[:len(SyncPattern)], SyncPattern[:]) {
+		e |= ErrSync
+	}
+	if msf := MSF(lba); !bytes.Equal(sector[12:15], msf[:]) {
+		e |= ErrAddress
+	}
+	if sector[15] != 1 {
+		e |= ErrMode
+	}
+	if EDC(sector[:edcOffset]) != binary.LittleEndian.Uint32(sector[edcOffset:]) {
+		e |= ErrEDC
+	}
+
+	var parity [SectorSize - pOffset]byte
+	eccP(sector, parity[:qOffset-pOffset])
+	if !bytes.Equal(parity[:qOffset-pOffset], sector[pOffset:qOffset]) {
+		e |= ErrECCP
+	}
+	eccQ(sector, parity[qOffset-pOffset:])
+	if !bytes.Equal(parity[qOffset-pOffset:], sector[qOffset:]) {
+		e |= ErrECCQ
+	}
+
+	return e
+}
+
+// pCodeword returns the offsets within the sector of each byte of the given
+// P codeword, which is a column of 24 bytes followed by its 2 parity bytes
+func pCodeword(major int) []int {
+	c := make([]int, 0, 26)
+	for minor := 0; minor < 24; minor++ {
+		c = append(c, 0xc+(major>>1)*2+major&1+minor*86)
+	}
+	return append(c, pOffset+major, pOffset+major+86)
+}
+
+// qCodeword returns the offsets within the sector of each byte of the given
+// Q codeword, which is a diagonal of 43 bytes followed by its 2 parity bytes
+func qCodeword(major int) []int {
+	c := make([]int, 0, 45)
+	index := (major>>1)*86 + major&1
+	for minor := 0; minor < 43; minor++ {
+		c = append(c, 0xc+index)
+		index = (index + 88) % (52 * 43)
+	}
+	return append(c, qOffset+major, qOffset+major+52)
+}
+
+// syndrome returns the first syndrome of a codeword, which for a single
+// damaged byte is the value it has been XOR'd with
+func syndrome(sector []byte, codeword []int) byte {
+	var s byte
+	for _, i := range codeword {
+		s ^= sector[i]
+	}
+	return s
+}
+
+// Repair attempts to fix the raw Mode 1 sector expected at the given
+// logical block address in place. The sync pattern and header are rebuilt
+// and a single damaged byte is located using the P and Q parity and
+// corrected, verified by the EDC. It reports whether the sector is now valid
+func Repair(sector []byte, lba int) bool {
+	copy(sector, SyncPattern[:])
+	msf := MSF(lba)
+	copy(sector[12:], msf[:])
+	sector[15] = 1
+
+	if Check(sector, lba)&ErrEDC == 0 {
+		// The data is good so only the parity can be damaged
+		Encode(sector, lba)
+		return true
+	}
+
+	var badP, badQ [][]int
+	for major := 0; major < 86; major++ {
+		if c := pCodeword(major); syndrome(sector, c) != 0 {
+			badP = append(badP, c)
+		}
+	}
+	for major := 0; major < 52; major++ {
+		if c := qCodeword(major); syndrome(sector, c) != 0 {
+			badQ = append(badQ, c)
+		}
+	}
+
+	// A single damaged byte lies where a damaged column and diagonal cross
+	for _, p := range badP {
+		in := make(map[int]struct{}, len(p))
+		for _, i := range p {
+			in[i] = struct{}{}
+		}
+		for _, q := range badQ {
+			for _, i := range q {
+				if _, ok := in[i]; !ok {
+					continue
+				}
+				s := syndrome(sector, p)
+				sector[i] ^= s
+				if EDC(sector[:edcOffset]) == binary.LittleEndian.Uint32(sector[edcOffset:]) {
+					Encode(sector, lba)
+					return true
+				}
+				sector[i] ^= s
+			}
+		}
+	}
+
+	return false
+}
@@ -1,14 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	img "image"
 	"image/png"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/bodgit/megasd"
 	"github.com/bodgit/megasd/image"
+	"github.com/bodgit/megasd/rom"
 	"github.com/urfave/cli/v2"
 )
 
@@ -21,6 +29,131 @@ func init() {
 	}
 }
 
+func writePNG(dir string, e megasd.Entry) error {
+	m, err := image.DecodeLenient(bytes.NewReader(e.Screenshot))
+	if err != nil {
+		return err
+	}
+
+	name := e.Name
+	if name == "" {
+		name = fmt.Sprintf("%08X", e.CRC)
+	}
+
+	f, err := os.Create(filepath.Join(dir, name+".png"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return png.Encode(f, m)
+}
+
+func printReport(r *megasd.Report) {
+	for _, s := range r.SerialMatches {
+		fmt.Printf("Matched \"%s\", with CRC \"%s\", to \"%s\" by serial \"%s\" (%s)\n", s.File, s.CRC, s.Game, s.Serial, s.Region)
+	}
+	for _, n := range r.NameMatches {
+		learned := ""
+		if n.Learned {
+			learned = ", CRC learned"
+		}
+		fmt.Printf("Matched \"%s\", with CRC \"%s\", to \"%s\" by name with score %.2f%s\n", n.File, n.CRC, n.Game, n.Score, learned)
+	}
+	for _, u := range r.Unmatched {
+		fmt.Printf("No match for \"%s\", with CRC \"%s\"", u.File, u.CRC)
+		if h := u.Header; h != nil {
+			title := h.OverseasTitle
+			if title == "" {
+				title = h.DomesticTitle
+			}
+			fmt.Printf(": %s, %s, %s", title, h.Serial, h.Regions)
+		}
+		fmt.Println()
+	}
+
+	for _, c := range r.Collisions {
+		reason := "CRC collision"
+		if c.Identical {
+			reason = "names are identical to the MegaSD"
+		}
+		fmt.Printf("Games in \"%s\" share CRC %08X (%s):\n", c.Dir, c.CRC, reason)
+		for _, name := range c.Names {
+			if newName, ok := c.Renamed[name]; ok {
+				fmt.Printf("\t%s -> %s\n", name, newName)
+			} else {
+				fmt.Printf("\t%s\n", name)
+			}
+		}
+	}
+
+	for _, d := range r.Discs {
+		printTracks(d.File, d.Tracks)
+	}
+
+	for _, l := range r.Large {
+		if len(l.Split) > 0 {
+			fmt.Printf("Moved %d games in \"%s\" into %d directories:\n", l.Games, l.Dir, len(l.Split))
+			for _, dir := range l.Split {
+				fmt.Printf("\t%s\n", filepath.Base(dir))
+			}
+			continue
+		}
+		fmt.Printf("Too many games in \"%s\", %d of %d left out of its metadata:\n", l.Dir, len(l.Omitted), l.Games)
+		for _, name := range l.Omitted {
+			fmt.Printf("\t%s\n", name)
+		}
+	}
+
+	for _, d := range r.DiscLayouts {
+		fmt.Printf("Problem with \"%s\": %s\n", d.Dir, d.Problem)
+	}
+}
+
+func printTracks(file string, tracks []megasd.TrackHash) {
+	fmt.Printf("Hashes for \"%s\":\n", file)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TRACK\tSIZE\tCRC\tMD5\tSHA-1\tNAME")
+	for _, t := range tracks {
+		if t.Skipped != "" {
+			fmt.Fprintf(w, "%02d\t-\t-\t-\t-\t%s\n", t.Track, t.Skipped)
+			continue
+		}
+		name := t.Name
+		if name == "" {
+			name = "-"
+		}
+		fmt.Fprintf(w, "%02d\t%d\t%s\t%s\t%s\t%s\n", t.Track, t.Size, t.CRC, t.MD5, t.SHA1, name)
+	}
+	w.Flush()
+}
+
+func printHeader(w io.Writer, h *rom.Header) {
+	fmt.Fprintf(w, "System:\t%s\n", h.System)
+	fmt.Fprintf(w, "Console:\t%s\n", h.Console)
+	if h.Copyright != "" {
+		fmt.Fprintf(w, "Copyright:\t%s\n", h.Copyright)
+	}
+	if !h.Date.IsZero() {
+		fmt.Fprintf(w, "Date:\t%s\n", h.Date.Format("2006-01"))
+	}
+	if h.DomesticTitle != "" {
+		fmt.Fprintf(w, "Domestic title:\t%s\n", h.DomesticTitle)
+	}
+	if h.OverseasTitle != "" {
+		fmt.Fprintf(w, "Overseas title:\t%s\n", h.OverseasTitle)
+	}
+	fmt.Fprintf(w, "Serial:\t%s\n", h.Serial)
+	fmt.Fprintf(w, "Version:\t%d\n", h.Version)
+	fmt.Fprintf(w, "Regions:\t%s\n", h.Regions)
+	if h.ROMEnd != 0 {
+		fmt.Fprintf(w, "ROM:\t%08X-%08X\n", h.ROMStart, h.ROMEnd)
+	}
+	if h.RAMEnd != 0 {
+		fmt.Fprintf(w, "RAM:\t%08X-%08X\n", h.RAMStart, h.RAMEnd)
+	}
+}
+
 func main() {
 	app := cli.NewApp()
 
@@ -109,16 +242,644 @@ func main() {
 				return nil
 			},
 		},
+		{
+			Name:        "import-dat",
+			Usage:       "Import No-Intro or Redump DAT files",
+			Description: "Logiqx XML and clrmamepro DAT files are supported for Mega Drive, 32X, Master System, SG-1000 and Mega CD",
+			ArgsUsage:   "FILE...",
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					cli.ShowCommandHelpAndExit(c, c.Command.FullName(), 1)
+				}
+
+				logger := log.New(ioutil.Discard, "", 0)
+				if c.Bool("verbose") {
+					logger.SetOutput(os.Stderr)
+				}
+
+				m, err := megasd.New(c.String("db"), logger)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				defer m.Close()
+
+				for _, file := range c.Args().Slice() {
+					if err := m.ImportDAT(file); err != nil {
+						return cli.NewExitError(err, 1)
+					}
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:        "rename",
+			Usage:       "Rename games to their canonical name",
+			Description: "Games are identified by checksum using the database and any imported DAT files. Each screenshot in the existing metadata is moved to the new name",
+			ArgsUsage:   "DIRECTORY",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "template",
+					Value: megasd.DefaultTemplate,
+					Usage: "template for each new name using {name}, {title}, {region}, {revision} and {tags}",
+				},
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "show what would be renamed",
+				},
+				&cli.StringFlag{
+					Name:  "undo-log",
+					Usage: "record each rename to `FILE`",
+				},
+				&cli.StringFlag{
+					Name:  "undo",
+					Usage: "reverse the renames recorded in `FILE`",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 && c.String("undo") == "" {
+					cli.ShowCommandHelpAndExit(c, c.Command.FullName(), 1)
+				}
+
+				logger := log.New(ioutil.Discard, "", 0)
+				if c.Bool("verbose") {
+					logger.SetOutput(os.Stderr)
+				}
+
+				m, err := megasd.New(c.String("db"), logger)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				defer m.Close()
+
+				if c.String("undo") != "" {
+					f, err := os.Open(c.String("undo"))
+					if err != nil {
+						return cli.NewExitError(err, 1)
+					}
+					defer f.Close()
+
+					if err := m.Undo(f); err != nil {
+						return cli.NewExitError(err, 1)
+					}
+
+					return nil
+				}
+
+				opts := []megasd.RenameOption{megasd.WithTemplate(c.String("template"))}
+				if c.Bool("dry-run") {
+					opts = append(opts, megasd.DryRun())
+				}
+				if c.String("undo-log") != "" {
+					f, err := os.OpenFile(c.String("undo-log"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+					if err != nil {
+						return cli.NewExitError(err, 1)
+					}
+					defer f.Close()
+
+					opts = append(opts, megasd.UndoLog(f))
+				}
+
+				renames, err := m.Rename(c.Args().First(), opts...)
+				for _, r := range renames {
+					fmt.Printf("%s -> %s\n", r.From, filepath.Base(r.To))
+				}
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:        "harvest",
+			Usage:       "Harvest screenshots from existing metadata",
+			Description: "Any screenshot, genre and year found in existing metadata is imported into the database",
+			ArgsUsage:   "DIRECTORY",
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					cli.ShowCommandHelpAndExit(c, c.Command.FullName(), 1)
+				}
+
+				logger := log.New(ioutil.Discard, "", 0)
+				if c.Bool("verbose") {
+					logger.SetOutput(os.Stderr)
+				}
+
+				m, err := megasd.New(c.String("db"), logger)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				defer m.Close()
+
+				if err := m.Harvest(c.Args().First()); err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:        "set",
+			Usage:       "Set the screenshot for a single game",
+			Description: "The metadata in the same directory as the ROM image or CD directory is updated directly without using the database",
+			ArgsUsage:   "FILE",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "image",
+					Usage: "PNG screenshot `FILE`",
+				},
+				&cli.StringFlag{
+					Name:  "genre",
+					Usage: "genre name or number",
+				},
+				&cli.IntFlag{
+					Name:  "year",
+					Usage: "year of release",
+				},
+				&cli.BoolFlag{
+					Name:  "remove",
+					Usage: "remove the entry instead",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					cli.ShowCommandHelpAndExit(c, c.Command.FullName(), 1)
+				}
+
+				if c.Bool("remove") {
+					if err := megasd.RemoveScreenshot(c.Args().First()); err != nil {
+						return cli.NewExitError(err, 1)
+					}
+					return nil
+				}
+
+				var m img.Image
+				if c.String("image") != "" {
+					f, err := os.Open(c.String("image"))
+					if err != nil {
+						return cli.NewExitError(err, 1)
+					}
+					defer f.Close()
+
+					if m, err = png.Decode(f); err != nil {
+						return cli.NewExitError(err, 1)
+					}
+				}
+
+				if err := megasd.SetScreenshot(c.Args().First(), m, c.String("genre"), c.Int("year")); err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:        "info",
+			Usage:       "Show the header of ROM images and CDs",
+			Description: "The header checksum of any ROM image is also verified. CDs are read using their cue sheet or ISO image",
+			ArgsUsage:   "FILE...",
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					cli.ShowCommandHelpAndExit(c, c.Command.FullName(), 1)
+				}
+
+				w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+				defer w.Flush()
+
+				for i, file := range c.Args().Slice() {
+					if i > 0 {
+						fmt.Fprintln(w)
+					}
+					fmt.Fprintf(w, "File:\t%s\n", file)
+
+					if ext := filepath.Ext(file); strings.EqualFold(ext, ".cue") || strings.EqualFold(ext, ".iso") {
+						h, err := megasd.DiscHeader(file)
+						if err != nil {
+							fmt.Fprintf(w, "Error:\t%s\n", err)
+							continue
+						}
+
+						fmt.Fprintf(w, "Disc ID:\t%s\n", h.DiscID)
+						fmt.Fprintf(w, "Volume name:\t%s\n", h.VolumeName)
+						fmt.Fprintf(w, "System name:\t%s\n", h.SystemName)
+						fmt.Fprintf(w, "Security region:\t%s\n", h.SecurityRegion)
+						printHeader(w, &h.Header)
+						continue
+					}
+
+					h, valid, err := megasd.ROMHeader(file)
+					if err != nil {
+						fmt.Fprintf(w, "Error:\t%s\n", err)
+						continue
+					}
+
+					printHeader(w, h)
+					status := "OK"
+					if !valid {
+						status = "mismatch"
+					}
+					fmt.Fprintf(w, "Checksum:\t%04X (%s)\n", h.Checksum, status)
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:        "convert-disc",
+			Usage:       "Convert CD images into a cue sheet and BINARY files",
+			Description: "CloneCD images, cue sheets with non-standard layouts and ISO images are rewritten without any subchannel data",
+			ArgsUsage:   "FILE...",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "output",
+					Usage: "write the converted disc to `DIR` instead of alongside the original, required for cue sheets",
+				},
+				&cli.BoolFlag{
+					Name:  "split",
+					Usage: "write each track to its own file instead of a single merged file",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					cli.ShowCommandHelpAndExit(c, c.Command.FullName(), 1)
+				}
+
+				for _, file := range c.Args().Slice() {
+					conv, err := megasd.ConvertDisc(file, c.String("output"), c.Bool("split"))
+					if err != nil {
+						return cli.NewExitError(err, 1)
+					}
+
+					fmt.Printf("%s -> %s (%s)\n", conv.From, conv.Cue, conv.CRC)
+					for _, f := range conv.Files {
+						fmt.Printf("\t%s\n", filepath.Base(f))
+					}
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:        "dump",
+			Usage:       "Dump the metadata for a directory",
+			Description: "Each entry is matched to the file or CD directory whose name hashes to the same CRC",
+			ArgsUsage:   "DIRECTORY",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "png",
+					Usage: "extract screenshots as PNG images into `DIRECTORY`",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					cli.ShowCommandHelpAndExit(c, c.Command.FullName(), 1)
+				}
+
+				dump, err := megasd.DumpDirectory(c.Args().First())
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+				fmt.Fprintln(w, "CRC\tNAME\tGENRE\tYEAR")
+				for _, e := range dump.Entries {
+					name, year := e.Name, ""
+					if name == "" {
+						name = "-"
+					}
+					if e.Year != 0 {
+						year = strconv.Itoa(e.Year)
+					}
+					fmt.Fprintf(w, "%08X\t%s\t%s\t%s\n", e.CRC, name, e.Genre, year)
+
+					if c.String("png") != "" {
+						if err := writePNG(c.String("png"), e); err != nil {
+							return cli.NewExitError(err, 1)
+						}
+					}
+				}
+				w.Flush()
+
+				for _, name := range dump.Missing {
+					fmt.Printf("No entry for \"%s\"\n", name)
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:        "lint",
+			Usage:       "Check cue sheets for problems with the MegaSD",
+			Description: "Missing files, case mismatches, unsupported track modes and audio formats, and folders with more than one cue sheet are reported",
+			ArgsUsage:   "PATH...",
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					cli.ShowCommandHelpAndExit(c, c.Command.FullName(), 1)
+				}
+
+				var errors int
+				for _, path := range c.Args().Slice() {
+					problems, err := megasd.LintCues(path)
+					if err != nil {
+						return cli.NewExitError(err, 1)
+					}
+
+					for _, p := range problems {
+						location := p.File
+						if p.Line > 0 {
+							location = fmt.Sprintf("%s:%d", p.File, p.Line)
+						}
+						fmt.Printf("%s: %s: %s\n\tfix: %s\n", location, p.Severity, p.Message, p.Fix)
+						if p.Severity == megasd.SeverityError {
+							errors++
+						}
+					}
+				}
+
+				if errors > 0 {
+					return cli.NewExitError(fmt.Sprintf("errors: %d", errors), 1)
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:        "verify-roms",
+			Usage:       "Check ROM images for bad dumps and header problems",
+			Description: "Each ROM image is checked against any imported DAT files and its own header",
+			ArgsUsage:   "DIRECTORY",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "problems",
+					Usage: "only show ROM images with problems",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					cli.ShowCommandHelpAndExit(c, c.Command.FullName(), 1)
+				}
+
+				logger := log.New(ioutil.Discard, "", 0)
+				if c.Bool("verbose") {
+					logger.SetOutput(os.Stderr)
+				}
+
+				m, err := megasd.New(c.String("db"), logger)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				defer m.Close()
+
+				results, err := m.VerifyROMs(c.Args().First())
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				for _, v := range results {
+					if c.Bool("problems") && v.Good() {
+						continue
+					}
+					fmt.Println(v.File)
+					for _, f := range v.Findings {
+						fmt.Printf("  %s: %s\n", f.Class, f.Explanation)
+					}
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:        "normalize",
+			Usage:       "Convert ROM images to plain big-endian images",
+			Description: "Any copier header is removed and interleaved or byte-swapped images are fixed. Each result is verified against any imported DAT files and its own header",
+			ArgsUsage:   "FILE|DIRECTORY",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "output",
+					Usage: "write the converted images into `DIRECTORY` rather than alongside the originals",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					cli.ShowCommandHelpAndExit(c, c.Command.FullName(), 1)
+				}
+
+				logger := log.New(ioutil.Discard, "", 0)
+				if c.Bool("verbose") {
+					logger.SetOutput(os.Stderr)
+				}
+
+				m, err := megasd.New(c.String("db"), logger)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				defer m.Close()
+
+				results, err := m.NormalizeAll(c.Args().First(), c.String("output"))
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				for _, n := range results {
+					if n.Fixed == 0 && n.From == n.To {
+						fmt.Printf("%s: already plain", n.From)
+					} else {
+						fmt.Printf("%s -> %s: fixed %s", n.From, n.To, n.Fixed)
+					}
+					switch {
+					case n.Name != "":
+						fmt.Printf(", matches \"%s\"", n.Name)
+					default:
+						fmt.Printf(", CRC %s doesn't match any DAT entry", n.CRC)
+					}
+					if n.Header != nil && !n.Valid {
+						fmt.Print(", header checksum mismatch")
+					}
+					fmt.Println()
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:        "patch",
+			Usage:       "Apply an IPS, BPS or UPS patch to a ROM image",
+			Description: "The CRC of the patched ROM image is recorded against the base game so it shares the same screenshot, genre and year",
+			ArgsUsage:   "ROM PATCH",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "output",
+					Usage: "write the patched ROM image to `FILE` rather than alongside the base ROM image",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 2 {
+					cli.ShowCommandHelpAndExit(c, c.Command.FullName(), 1)
+				}
+
+				logger := log.New(ioutil.Discard, "", 0)
+				if c.Bool("verbose") {
+					logger.SetOutput(os.Stderr)
+				}
+
+				m, err := megasd.New(c.String("db"), logger)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				defer m.Close()
+
+				p, err := m.Patch(c.Args().Get(0), c.Args().Get(1), c.String("output"))
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				fmt.Printf("Applied %s patch to create \"%s\", with CRC %s\n", p.Format, p.File, p.CRC)
+				if p.Game != "" {
+					fmt.Printf("Recorded against \"%s\"\n", p.Game)
+				} else {
+					fmt.Println("The base ROM image isn't in the database so the CRC wasn't recorded")
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:        "hash-disc",
+			Usage:       "Hash CDs in the same form as Redump",
+			Description: "The data track is hashed as raw 2352 byte sectors and matched against any imported Redump DAT files. The hashes are stored alongside the CRC used by the MegaSD",
+			ArgsUsage:   "FILE...",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "all-tracks",
+					Usage: "hash every track rather than just the data track",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					cli.ShowCommandHelpAndExit(c, c.Command.FullName(), 1)
+				}
+
+				logger := log.New(ioutil.Discard, "", 0)
+				if c.Bool("verbose") {
+					logger.SetOutput(os.Stderr)
+				}
+
+				m, err := megasd.New(c.String("db"), logger)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				defer m.Close()
+
+				for _, file := range c.Args().Slice() {
+					tracks, err := m.HashDisc(file, c.Bool("all-tracks"))
+					if err != nil {
+						return cli.NewExitError(err, 1)
+					}
+					printTracks(file, tracks)
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:        "verify-disc",
+			Usage:       "Check CD images for damaged sectors",
+			Description: "The sync pattern, address, EDC and ECC of every sector of each MODE1/2352 data track is checked",
+			ArgsUsage:   "CUE...",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "repair",
+					Usage: "repair any damaged sectors in place where possible",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() < 1 {
+					cli.ShowCommandHelpAndExit(c, c.Command.FullName(), 1)
+				}
+
+				var unrepaired int
+				for _, file := range c.Args().Slice() {
+					results, err := megasd.VerifyDisc(file, c.Bool("repair"))
+					if err != nil {
+						return cli.NewExitError(err, 1)
+					}
+
+					for _, v := range results {
+						fmt.Printf("Track %02d of \"%s\": %d sectors, %d damaged\n", v.Track, v.File, v.Sectors, len(v.Damaged))
+						for _, d := range v.Damaged {
+							status := ""
+							if d.Repaired {
+								status = " (repaired)"
+							} else {
+								unrepaired++
+							}
+							fmt.Printf("\tLBA %d at offset %d: %s%s\n", d.LBA, d.Offset, d.Errors, status)
+						}
+					}
+				}
+
+				if unrepaired > 0 {
+					return cli.NewExitError(fmt.Sprintf("damaged sectors: %d", unrepaired), 1)
+				}
+
+				return nil
+			},
+		},
 		{
 			Name:        "scan",
 			Usage:       "Scan filesystem and generate metadata",
 			Description: "",
 			ArgsUsage:   "DIRECTORY",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "rename-collisions",
+					Usage: "rename games whose names hash to the same CRC",
+				},
+				&cli.BoolFlag{
+					Name:  "match-names",
+					Usage: "match games with an unknown CRC and serial by their name",
+				},
+				&cli.Float64Flag{
+					Name:  "name-threshold",
+					Usage: "minimum similarity score, from 0 to 1, for --match-names",
+					Value: 0.9,
+				},
+				&cli.BoolFlag{
+					Name:  "learn-names",
+					Usage: "add the CRC of each game matched by --match-names to the database",
+				},
+				&cli.Float64Flag{
+					Name:  "learn-threshold",
+					Usage: "minimum similarity score, from 0 to 1, for --learn-names",
+					Value: 1,
+				},
+				&cli.BoolFlag{
+					Name:  "split-large",
+					Usage: "move the games in any directory with too many for its metadata into alphabetical sub-directories",
+				},
+				&cli.StringFlag{
+					Name:  "split-log",
+					Usage: "record each move made by --split-large to `FILE`, which megasd rename --undo reverses",
+				},
+				&cli.BoolFlag{
+					Name:  "redump",
+					Usage: "also hash the data track of every CD to match against Redump DAT files",
+				},
+				&cli.BoolFlag{
+					Name:  "all-tracks",
+					Usage: "hash every track of every CD, implies --redump",
+				},
+			},
 			Action: func(c *cli.Context) error {
 				if c.NArg() < 1 {
 					cli.ShowCommandHelpAndExit(c, c.Command.FullName(), 1)
 				}
 
+				if c.Bool("learn-names") && !c.Bool("match-names") {
+					return cli.NewExitError("--learn-names needs --match-names", 1)
+				}
+
 				logger := log.New(ioutil.Discard, "", 0)
 				if c.Bool("verbose") {
 					logger.SetOutput(os.Stderr)
@@ -130,10 +891,39 @@ func main() {
 				}
 				defer m.Close()
 
-				if err := m.Scan(c.Args().First()); err != nil {
+				var opts []megasd.ScanOption
+				if c.Bool("rename-collisions") {
+					opts = append(opts, megasd.RenameCollisions())
+				}
+				if c.Bool("match-names") {
+					opts = append(opts, megasd.MatchNames(c.Float64("name-threshold")))
+				}
+				if c.Bool("learn-names") {
+					opts = append(opts, megasd.LearnNames(c.Float64("learn-threshold")))
+				}
+				if c.Bool("split-large") {
+					opts = append(opts, megasd.SplitDirectories())
+				}
+				if c.String("split-log") != "" {
+					f, err := os.OpenFile(c.String("split-log"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+					if err != nil {
+						return cli.NewExitError(err, 1)
+					}
+					defer f.Close()
+
+					opts = append(opts, megasd.SplitLog(f))
+				}
+				if c.Bool("redump") || c.Bool("all-tracks") {
+					opts = append(opts, megasd.HashTracks(c.Bool("all-tracks")))
+				}
+
+				r, err := m.Scan(c.Args().First(), opts...)
+				if err != nil {
 					return cli.NewExitError(err, 1)
 				}
 
+				printReport(r)
+
 				return nil
 			},
 		},
@@ -0,0 +1,183 @@
+package megasd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bodgit/megasd/metadata"
+)
+
+// The firmware only considers this many bytes of each filename
+const filenameTrim = 56
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 sequence
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// displayName returns the file, or CD directory, name of a game
+func (c candidate) displayName() string {
+	return filepath.Base(c.file())
+}
+
+// file returns the path that needs renaming to rename the game
+func (c candidate) file() string {
+	if c.cd() {
+		return filepath.Dir(c.path)
+	}
+	return c.path
+}
+
+// findCollisions groups together any games that hash to the same CRC
+func findCollisions(games []candidate) [][]candidate {
+	crcs := make(map[uint32][]candidate)
+	for _, g := range games {
+		crc := metadata.CRCFilename(g.name)
+		crcs[crc] = append(crcs[crc], g)
+	}
+
+	var groups [][]candidate
+	for _, group := range crcs {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool { return group[i].displayName() < group[j].displayName() })
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0].displayName() < groups[j][0].displayName() })
+
+	return groups
+}
+
+// uniqueName returns a variation of name that doesn't hash to any of the
+// CRCs already in use
+func uniqueName(name string, used map[uint32]struct{}) (string, error) {
+	for i := 2; i < 100; i++ {
+		suffix := fmt.Sprintf(" (%d)", i)
+		candidate := truncate(name, filenameTrim-len(suffix)) + suffix
+		if _, ok := used[metadata.CRCFilename(candidate)]; !ok {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("unable to find a unique name for \"%s\"", name)
+}
+
+// renameGame renames the file or CD directory of a game, preserving any
+// file extension
+func renameGame(g candidate, name string) (string, error) {
+	oldPath := g.file()
+	newPath := filepath.Join(filepath.Dir(oldPath), name+strings.TrimPrefix(filepath.Base(oldPath), g.name))
+
+	// Allow changing just the case on a case-insensitive filesystem
+	if _, err := os.Lstat(newPath); err == nil && !strings.EqualFold(oldPath, newPath) {
+		return "", fmt.Errorf("unable to rename \"%s\", \"%s\" already exists", oldPath, newPath)
+	}
+
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return "", err
+	}
+
+	return newPath, nil
+}
+
+func (m *MegaSD) checkCollisions(dir string, o *scanOptions, r *Report) error {
+	games, err := candidates(dir)
+	if err != nil {
+		return err
+	}
+
+	groups := findCollisions(games)
+	if len(groups) == 0 {
+		return nil
+	}
+
+	used := make(map[uint32]struct{})
+	for _, g := range games {
+		used[metadata.CRCFilename(g.name)] = struct{}{}
+	}
+
+	for _, group := range groups {
+		c := Collision{
+			Dir:       dir,
+			CRC:       metadata.CRCFilename(group[0].name),
+			Identical: true,
+		}
+		for _, g := range group {
+			c.Names = append(c.Names, g.displayName())
+			if !metadata.EqualFilename(group[0].name, g.name) {
+				c.Identical = false
+			}
+		}
+
+		m.logger.Printf("Collision in \"%s\" for CRC \"%08X\" between %q\n", dir, c.CRC, c.Names)
+
+		// Keep the first game and rename the rest
+		if o.renameCollisions {
+			c.Renamed = make(map[string]string)
+			for _, g := range group[1:] {
+				name, err := uniqueName(g.name, used)
+				if err != nil {
+					return err
+				}
+
+				path, err := renameGame(g, name)
+				if err != nil {
+					return err
+				}
+				used[metadata.CRCFilename(name)] = struct{}{}
+
+				c.Renamed[g.displayName()] = filepath.Base(path)
+				m.logger.Printf("Renamed \"%s\" to \"%s\"\n", g.file(), path)
+			}
+		}
+
+		r.addCollision(c)
+	}
+
+	return nil
+}
+
+func (m *MegaSD) checkAllCollisions(base string, o *scanOptions, r *Report) error {
+	var dirs []string
+	if err := filepath.Walk(base, func(dir string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Ignore any hidden files or directories
+		if info.Name()[0] == '.' {
+			if info.Mode().IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.Mode().IsDir() {
+			dirs = append(dirs, dir)
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// Work from the deepest directory upwards so renaming a CD directory
+	// doesn't invalidate any path still to be checked
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := m.checkCollisions(dirs[i], o, r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
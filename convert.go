@@ -0,0 +1,296 @@
+package megasd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bodgit/megasd/cd"
+	"github.com/bodgit/megasd/cue"
+	"github.com/bodgit/megasd/rom"
+)
+
+// Conversion describes a disc rewritten into a cue sheet and BINARY files
+type Conversion struct {
+	From  string
+	Cue   string
+	Files []string
+	CRC   string // CRC of the first data sector, the same before and after
+}
+
+// extentsCRC computes the CRC of the first data sector of the disc the same
+// way as the MegaSD firmware
+func extentsCRC(extents []trackExtent) (string, error) {
+	for _, e := range extents {
+		if e.track.Mode != cue.Mode1Cooked && e.track.Mode != cue.Mode1Raw {
+			continue
+		}
+
+		offset := e.offset + int64(e.track.Start()-firstIndex(e.track))*int64(e.track.SectorSize())
+		b, err := readSector(e.file, offset, e.track.Mode == cue.Mode1Raw)
+		if err != nil {
+			return "", err
+		}
+
+		return crcSector(b)
+	}
+
+	return "", errors.New("audio-only CDs are not supported for hashing")
+}
+
+// copyTrack writes the sectors of the track to w as little-endian BINARY
+// data. Any subchannel data stored with each sector is dropped and returned
+// is the mode of the track as written
+func copyTrack(w io.Writer, e trackExtent) (string, error) {
+	f, err := os.Open(e.file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	size := e.track.SectorSize()
+	r := bufio.NewReader(io.NewSectionReader(f, e.offset, int64(e.sectors())*int64(size)))
+
+	switch {
+	case e.track.Mode == "CDG":
+		b := make([]byte, size)
+		for i := 0; i < e.sectors(); i++ {
+			if _, err := io.ReadFull(r, b); err != nil {
+				return "", err
+			}
+			if _, err := w.Write(b[:cd.SectorSize]); err != nil {
+				return "", err
+			}
+		}
+		return cue.ModeAudio, nil
+	case e.kind == "MOTOROLA" && !e.track.IsData():
+		b := make([]byte, size)
+		for i := 0; i < e.sectors(); i++ {
+			if _, err := io.ReadFull(r, b); err != nil {
+				return "", err
+			}
+			if _, err := w.Write(rom.SwapBytes(b)); err != nil {
+				return "", err
+			}
+		}
+	default:
+		if _, err := io.Copy(w, r); err != nil {
+			return "", err
+		}
+	}
+
+	return e.track.Mode, nil
+}
+
+// convertedTrack returns a copy of the track for the new cue sheet, with
+// its indexes moved to start at the given frame
+func convertedTrack(t *cue.Track, mode string, frame int) *cue.Track {
+	c := &cue.Track{
+		Number:  t.Number,
+		Mode:    mode,
+		Pregap:  t.Pregap,
+		Postgap: t.Postgap,
+	}
+	for _, i := range t.Indexes {
+		c.Indexes = append(c.Indexes, cue.Index{Number: i.Number, Frame: frame + i.Frame - firstIndex(t)})
+	}
+	if len(c.Indexes) == 0 {
+		c.Indexes = []cue.Index{{Number: 1, Frame: frame}}
+	}
+	return c
+}
+
+// sameDir reports whether both paths are the same directory
+func sameDir(a, b string) bool {
+	x, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	y, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(x, y)
+}
+
+// discWriter creates the files of a converted disc, never replacing an
+// existing file
+type discWriter struct {
+	files []string
+}
+
+func (dw *discWriter) create(file string) (*os.File, error) {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	dw.files = append(dw.files, file)
+	return f, nil
+}
+
+// remove removes every file created so far
+func (dw *discWriter) remove() {
+	for _, file := range dw.files {
+		os.Remove(file)
+	}
+}
+
+// copyFile copies a file that can't be converted, such as lossy audio, as is
+func (dw *discWriter) copyFile(src, dst string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := dw.create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// ConvertDisc rewrites the disc described by the CloneCD control file, cue
+// sheet or ISO image into a cue sheet and BINARY files in dir, or alongside
+// the original if dir is empty. A cue sheet must be converted into another
+// directory as the new files would have the same names. The tracks are
+// either merged into a single file or, if split is true, each written to
+// their own file. Subchannel data is dropped and the first data sector is
+// checked to still have the same CRC. Existing files are never replaced
+func ConvertDisc(file, dir string, split bool) (*Conversion, error) {
+	extents, err := discExtents(file)
+	if err != nil {
+		return nil, err
+	}
+
+	crc, err := extentsCRC(extents)
+	if err != nil {
+		return nil, err
+	}
+
+	if dir == "" {
+		dir = filepath.Dir(file)
+	}
+	if isCue(file) && sameDir(dir, filepath.Dir(file)) {
+		// The converted cue sheet and tracks would have the same names as
+		// the original
+		return nil, fmt.Errorf("\"%s\" must be converted into another directory", file)
+	}
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+
+	c := &Conversion{
+		From: file,
+		Cue:  filepath.Join(dir, name+".cue"),
+		CRC:  crc,
+	}
+
+	dw := new(discWriter)
+	ok := false
+	defer func() {
+		if !ok {
+			dw.remove()
+		}
+	}()
+
+	sheet := new(cue.Sheet)
+
+	var merged *os.File
+	var frame int
+	for _, e := range extents {
+		if e.length == 0 && e.kind != "BINARY" {
+			// Lossy audio is kept as is in its own file
+			if !split {
+				return nil, fmt.Errorf("track %d is %s audio which can't be merged", e.track.Number, e.kind)
+			}
+			dst := filepath.Join(dir, filepath.Base(e.file))
+			if err := dw.copyFile(e.file, dst); err != nil {
+				return nil, err
+			}
+			sheet.Files = append(sheet.Files, &cue.File{
+				Name:   filepath.Base(dst),
+				Type:   e.kind,
+				Tracks: []*cue.Track{convertedTrack(e.track, e.track.Mode, 0)},
+			})
+			continue
+		}
+
+		var w *os.File
+		switch {
+		case !split && merged != nil:
+			w = merged
+		case !split:
+			if merged, err = dw.create(filepath.Join(dir, name+".bin")); err != nil {
+				return nil, err
+			}
+			defer merged.Close()
+			w = merged
+			sheet.Files = append(sheet.Files, &cue.File{Name: filepath.Base(merged.Name()), Type: "BINARY"})
+		default:
+			track := name + ".bin"
+			if len(extents) > 1 {
+				track = fmt.Sprintf("%s (Track %02d).bin", name, e.track.Number)
+			}
+			if w, err = dw.create(filepath.Join(dir, track)); err != nil {
+				return nil, err
+			}
+			frame = 0
+			sheet.Files = append(sheet.Files, &cue.File{Name: track, Type: "BINARY"})
+		}
+
+		mode, err := copyTrack(w, e)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		if split {
+			if err := w.Close(); err != nil {
+				return nil, err
+			}
+		}
+
+		f := sheet.Files[len(sheet.Files)-1]
+		f.Tracks = append(f.Tracks, convertedTrack(e.track, mode, frame))
+		frame += e.sectors()
+	}
+
+	if merged != nil {
+		if err := merged.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	w, err := dw.create(c.Cue)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := sheet.WriteTo(w); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	after, err := crcDiscFile(c.Cue)
+	if err != nil {
+		return nil, err
+	}
+	if after != crc {
+		return nil, fmt.Errorf("first data sector CRC changed from \"%s\" to \"%s\"", crc, after)
+	}
+
+	for _, f := range sheet.Files {
+		c.Files = append(c.Files, filepath.Join(dir, f.Name))
+	}
+	ok = true
+
+	return c, nil
+}
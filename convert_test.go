@@ -0,0 +1,124 @@
+package megasd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bodgit/megasd/cue"
+	"github.com/stretchr/testify/assert"
+)
+
+const convertCRC = "D1770697"
+
+func TestParseCCD(t *testing.T) {
+	tracks, err := parseCCD(filepath.Join("testdata", "convert", "disc.ccd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if assert.Len(t, tracks, 2) {
+		assert.Equal(t, cue.Mode1Raw, tracks[0].Mode)
+		assert.Equal(t, []cue.Index{{Number: 1, Frame: 0}}, tracks[0].Indexes)
+		assert.Equal(t, cue.ModeAudio, tracks[1].Mode)
+		assert.Equal(t, []cue.Index{{Number: 0, Frame: 2}, {Number: 1, Frame: 3}}, tracks[1].Indexes)
+	}
+}
+
+func TestCCDExtents(t *testing.T) {
+	extents, err := ccdExtents(filepath.Join("testdata", "convert", "disc.ccd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tables := []struct {
+		offset, length int64
+		lba            int
+	}{
+		{0, 2 * 2352, 0},
+		{2 * 2352, 3 * 2352, 2},
+	}
+
+	if assert.Len(t, extents, len(tables)) {
+		for i, table := range tables {
+			assert.Equal(t, table.offset, extents[i].offset)
+			assert.Equal(t, table.length, extents[i].length)
+			assert.Equal(t, table.lba, extents[i].lba)
+		}
+	}
+}
+
+func TestConvertDisc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "megasd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tables := []struct {
+		file  string
+		split bool
+		cue   string
+		files map[string]string // Converted file and the file it should equal
+	}{
+		{
+			"disc.ccd",
+			false,
+			"FILE \"disc.bin\" BINARY\r\n  TRACK 01 MODE1/2352\r\n    INDEX 01 00:00:00\r\n  TRACK 02 AUDIO\r\n    INDEX 00 00:00:02\r\n    INDEX 01 00:00:03\r\n",
+			map[string]string{"disc.bin": "disc.img"},
+		},
+		{
+			"multi.cue",
+			true,
+			"",
+			map[string]string{
+				"multi.cue":            "multi.cue",
+				"multi (Track 01).bin": "multi (Track 01).bin",
+				"multi (Track 02).bin": "multi (Track 02).bin",
+			},
+		},
+	}
+
+	for _, table := range tables {
+		c, err := ConvertDisc(filepath.Join("testdata", "convert", table.file), dir, table.split)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, convertCRC, c.CRC, table.file)
+
+		crc, err := crcDiscFile(c.Cue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, convertCRC, crc, table.file)
+
+		if table.cue != "" {
+			b, err := ioutil.ReadFile(c.Cue)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, table.cue, string(b), table.file)
+		}
+
+		for file, original := range table.files {
+			b, err := ioutil.ReadFile(filepath.Join(dir, file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected, err := ioutil.ReadFile(filepath.Join("testdata", "convert", original))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, expected, b, file)
+		}
+	}
+}
+
+func TestConvertDiscAlongside(t *testing.T) {
+	_, err := ConvertDisc(filepath.Join("testdata", "convert", "multi.cue"), "", true)
+	assert.Error(t, err)
+
+	_, err = os.Stat(filepath.Join("testdata", "convert", "multi.cue"))
+	assert.NoError(t, err)
+}
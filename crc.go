@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"hash/crc32"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
-	"github.com/vchimishuk/chub/cue"
+	"github.com/bodgit/megasd/cue"
+	"github.com/bodgit/megasd/rom"
 )
 
 const (
@@ -18,59 +20,120 @@ const (
 	sectorTrailer = 288
 )
 
-func firstDataTrack(sheet *cue.Sheet) (string, cue.TrackDataType, error) {
+// syncPattern starts every raw 2352 byte data sector
+var syncPattern = []byte{0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00}
+
+func firstDataTrack(sheet *cue.Sheet) (*cue.File, *cue.Track, error) {
 	for _, file := range sheet.Files {
 		for _, track := range file.Tracks {
-			switch track.DataType {
-			case cue.DataTypeMode1_2048, cue.DataTypeMode1_2352:
-				return file.Name, track.DataType, nil
+			switch track.Mode {
+			case cue.Mode1Cooked, cue.Mode1Raw:
+				return file, track, nil
 			}
 		}
 	}
-	return "", cue.DataTypeAudio, errors.New("audio-only CDs are not supported for hashing")
+	return nil, nil, errors.New("audio-only CDs are not supported for hashing")
 }
 
-func crcCueFile(file string) (string, error) {
-	sheet, err := cue.ParseFile(file)
+// readSector reads the user data of a sector at the given offset. If the
+// sector is raw then the sync pattern and header are skipped
+func readSector(file string, offset int64, raw bool) ([]byte, error) {
+	f, err := os.Open(file)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	defer f.Close()
+
+	if raw {
+		offset += sectorHeader
 	}
 
-	fileName, dataType, err := firstDataTrack(sheet)
+	b := make([]byte, sectorSize)
+	if _, err := f.ReadAt(b, offset); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// firstSector returns the user data from the first sector of the first data
+// track in the cue sheet. The data track may not be at the start of its file
+// so it's located by its INDEX 01 position
+func firstSector(dir string, sheet *cue.Sheet) ([]byte, error) {
+	file, track, err := firstDataTrack(sheet)
 	if err != nil {
-		return "", nil
+		return nil, err
 	}
 
-	f, err := os.Open(filepath.Join(filepath.Dir(file), fileName))
+	offset, err := file.Offset(track, track.Start())
 	if err != nil {
-		return "", nil
+		return nil, err
+	}
+
+	return readSector(filepath.Join(dir, file.Name), offset, track.Mode == cue.Mode1Raw)
+}
+
+// isoSector returns the user data from the first sector of a standalone ISO
+// image, which may contain raw sectors despite its name
+func isoSector(file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
 	}
 	defer f.Close()
 
-	if dataType == cue.DataTypeMode1_2352 {
-		if _, err := f.Seek(sectorHeader, os.SEEK_CUR); err != nil {
-			return "", nil
-		}
+	b := make([]byte, len(syncPattern))
+	if _, err := io.ReadFull(f, b); err != nil {
+		return nil, err
 	}
 
-	h := crc32.NewIEEE()
-	var b bytes.Buffer
+	return readSector(file, 0, bytes.Equal(b, syncPattern))
+}
+
+// discSector returns the user data from the first sector of the data track
+// of a disc described by either a cue sheet or a standalone ISO image
+func discSector(file string) ([]byte, error) {
+	if !isCue(file) {
+		return isoSector(file)
+	}
+
+	sheet, err := cue.ParseFile(file)
+	if err != nil {
+		return nil, err
+	}
 
-	if _, err := io.CopyN(io.MultiWriter(h, &b), f, sectorSize); err != nil {
+	return firstSector(filepath.Dir(file), sheet)
+}
+
+// crcDiscFile computes the CRC of the first sector of the data track the
+// same way as the MegaSD firmware
+func crcDiscFile(file string) (string, error) {
+	b, err := discSector(file)
+	if err != nil {
 		return "", nil
 	}
 
-	if bytes.Compare(b.Bytes()[0x100:0x104], []byte{'S', 'E', 'G', 'A'}) != 0 {
+	return crcSector(b)
+}
+
+// crcSector computes the CRC of the user data of the first data sector
+func crcSector(b []byte) (string, error) {
+	if bytes.Compare(b[0x100:0x104], []byte{'S', 'E', 'G', 'A'}) != 0 {
 		return "", errors.New("invalid signature")
 	}
 
-	if dataType == cue.DataTypeMode1_2352 {
-		if _, err := f.Seek(sectorTrailer, os.SEEK_CUR); err != nil {
-			return "", nil
-		}
+	return fmt.Sprintf("%.*X", crc32.Size<<1, crc32.ChecksumIEEE(b)), nil
+}
+
+// DiscHeader parses the header in the first data sector of the disc
+// described by the given cue sheet or ISO image
+func DiscHeader(file string) (*rom.DiscHeader, error) {
+	b, err := discSector(file)
+	if err != nil {
+		return nil, err
 	}
 
-	return fmt.Sprintf("%.*X", crc32.Size<<1, h.Sum(nil)), nil
+	return rom.ParseDisc(b)
 }
 
 func crcFile(file string) (string, error) {
@@ -96,3 +159,29 @@ func crcFile(file string) (string, error) {
 
 	return fmt.Sprintf("%.*X", crc32.Size<<1, h.Sum(nil)), nil
 }
+
+// readROM reads a ROM image, skipping any copier header the same way as
+// crcFile
+func readROM(file string) ([]byte, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return b[len(b)&0xfff:], nil
+}
+
+// ROMHeader parses the header of the given ROM image and reports whether
+// the checksum in the header matches the contents
+func ROMHeader(file string) (*rom.Header, bool, error) {
+	b, err := readROM(file)
+	if err != nil {
+		return nil, false, err
+	}
+
+	h, err := rom.Parse(b)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return h, h.Verify(b), nil
+}
@@ -32,22 +32,42 @@ const polynomial = 0x04c11db7
 
 var table = makeTable(polynomial)
 
+// Initial is the initial value used by the MegaSD firmware, which New and
+// Checksum don't use. Pass it to NewWithInitial or Update instead.
+const Initial = 0xffffffff
+
+// The data is consumed in 32-bit words, with the bytes of each word
+// processed in reverse order.
+const wordSize = 4
+
 type digest struct {
-	crc uint32
-	tab *crc.Table
+	crc  uint32
+	init uint32
+	tab  *crc.Table
+	buf  [wordSize]byte
+	n    int
 }
 
-// New creates a new hash.Hash32 computing the CRC-32 checksum. Its Sum
-// method will lay the value out in big-endian byte order.
+// New creates a new hash.Hash32 computing the CRC-32 checksum starting from
+// zero. Its Sum method will lay the value out in big-endian byte order.
 func New() hash.Hash32 {
-	return &digest{0, table}
+	return NewWithInitial(0)
+}
+
+// NewWithInitial creates a new hash.Hash32 computing the CRC-32 checksum
+// starting from the given initial value.
+func NewWithInitial(init uint32) hash.Hash32 {
+	return &digest{crc: init, init: init, tab: table}
 }
 
 func (d *digest) Size() int { return crc.Size }
 
-func (d *digest) BlockSize() int { return 1 }
+func (d *digest) BlockSize() int { return wordSize }
 
-func (d *digest) Reset() { d.crc = 0 }
+func (d *digest) Reset() {
+	d.crc = d.init
+	d.n = 0
+}
 
 func update(crc uint32, tab *crc.Table, p []byte) uint32 {
 	for i := range p {
@@ -56,22 +76,58 @@ func update(crc uint32, tab *crc.Table, p []byte) uint32 {
 	return crc
 }
 
-// Update returns the result of adding the bytes in p to the crc.
+// Update returns the result of adding the bytes in p to the crc. If the
+// length of p is not a multiple of four then the final word is padded with
+// zeroes.
 func Update(crc uint32, p []byte) uint32 {
-	return update(crc, table, p)
+	n := len(p) &^ (wordSize - 1)
+	crc = update(crc, table, p[:n])
+	if n < len(p) {
+		var tmp [wordSize]byte
+		copy(tmp[:], p[n:])
+		crc = update(crc, table, tmp[:])
+	}
+	return crc
 }
 
 func (d *digest) Write(p []byte) (n int, err error) {
-	d.crc = update(d.crc, d.tab, p)
-	return len(p), nil
+	n = len(p)
+
+	// Complete any partial word from a previous write
+	if d.n > 0 {
+		i := copy(d.buf[d.n:], p)
+		d.n += i
+		p = p[i:]
+		if d.n < wordSize {
+			return n, nil
+		}
+		d.crc = update(d.crc, d.tab, d.buf[:])
+		d.n = 0
+	}
+
+	// Process whole words and keep any remainder for later
+	i := len(p) &^ (wordSize - 1)
+	d.crc = update(d.crc, d.tab, p[:i])
+	d.n = copy(d.buf[:], p[i:])
+
+	return n, nil
 }
 
-func (d *digest) Sum32() uint32 { return d.crc }
+// Sum32 returns the checksum of the data written so far. Any partial word is
+// padded with zeroes, without affecting subsequent writes.
+func (d *digest) Sum32() uint32 {
+	if d.n == 0 {
+		return d.crc
+	}
+	var tmp [wordSize]byte
+	copy(tmp[:], d.buf[:d.n])
+	return update(d.crc, d.tab, tmp[:])
+}
 
 func (d *digest) Sum(in []byte) []byte {
 	s := d.Sum32()
 	return append(in, byte(s>>24), byte(s>>16), byte(s>>8), byte(s))
 }
 
-// Checksum returns the CRC-32 checksum of data.
+// Checksum returns the CRC-32 checksum of data starting from zero.
 func Checksum(data []byte) uint32 { return Update(0, data) }
@@ -0,0 +1,51 @@
+package crc32
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitWrites(t *testing.T) {
+	data := []byte("The quick brown fox jumps over the lazy dog!")
+	want := Checksum(data)
+
+	for i := 0; i <= len(data); i++ {
+		for j := i; j <= len(data); j++ {
+			h := New()
+			h.Write(data[:i])
+			h.Write(data[i:j])
+			h.Write(data[j:])
+			assert.Equal(t, want, h.Sum32(), "split at %d and %d", i, j)
+		}
+	}
+}
+
+func TestPartialWord(t *testing.T) {
+	h := New()
+	h.Write([]byte("abcde"))
+	assert.Equal(t, Checksum([]byte("abcde\x00\x00\x00")), h.Sum32())
+
+	// Sum32 doesn't consume the partial word
+	h.Write([]byte("fgh"))
+	assert.Equal(t, Checksum([]byte("abcdefgh")), h.Sum32())
+}
+
+func TestInitial(t *testing.T) {
+	data := []byte("SONIC THE HEDGEHOG")
+
+	h := NewWithInitial(0)
+	h.Write(data)
+	assert.Equal(t, Update(0, data), h.Sum32())
+
+	h = NewWithInitial(Initial)
+	h.Write(data)
+	h.Reset()
+	assert.Equal(t, uint32(Initial), h.Sum32())
+
+	// New and Checksum keep starting from zero
+	h = New()
+	h.Write(data)
+	assert.Equal(t, Checksum(data), h.Sum32())
+	assert.Equal(t, Update(0, data), Checksum(data))
+}
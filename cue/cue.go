@@ -0,0 +1,325 @@
+/*
+Package cue parses the cue sheets that describe the layout of a CD image.
+
+The parser is deliberately lenient as cue sheets found in the wild often use
+file types such as OGG or FLAC that aren't part of the original
+specification, so any file type or track mode is accepted and it is left to
+the caller to decide what is supported. Unrecognised commands are ignored.
+*/
+package cue
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FramesPerSecond is the number of frames, or sectors, in each second of a
+// CD
+const FramesPerSecond = 75
+
+// Track modes commonly found in cue sheets
+const (
+	ModeAudio   = "AUDIO"
+	Mode1Cooked = "MODE1/2048"
+	Mode1Raw    = "MODE1/2352"
+	Mode2Cooked = "MODE2/2336"
+	Mode2Raw    = "MODE2/2352"
+)
+
+// Sheet is a parsed cue sheet
+type Sheet struct {
+	Files []*File
+}
+
+// File is a single FILE entry in a cue sheet
+type File struct {
+	Name   string
+	Type   string // Upper-cased, such as "BINARY", "WAVE" or "OGG"
+	Tracks []*Track
+	Line   int
+}
+
+// Track is a single TRACK entry in a cue sheet
+type Track struct {
+	Number  int
+	Mode    string // Upper-cased, such as "MODE1/2352" or "AUDIO"
+	Indexes []Index
+	Pregap  int // Length of any pregap not stored in the file, in frames
+	Postgap int // Length of any postgap not stored in the file, in frames
+	Line    int
+}
+
+// Index is a single INDEX entry in a cue sheet
+type Index struct {
+	Number int
+	Frame  int // Offset from the start of the file, in frames
+}
+
+// IsData reports whether the track holds data rather than audio
+func (t *Track) IsData() bool {
+	return strings.HasPrefix(t.Mode, "MODE") || strings.HasPrefix(t.Mode, "CDI")
+}
+
+// SectorSize returns the size of each sector of the track as stored in the
+// file, or zero if the mode isn't recognised
+func (t *Track) SectorSize() int {
+	switch t.Mode {
+	case Mode1Cooked:
+		return 2048
+	case Mode2Cooked, "CDI/2336":
+		return 2336
+	case ModeAudio, Mode1Raw, Mode2Raw, "CDI/2352":
+		return 2352
+	case "CDG":
+		return 2448
+	}
+	return 0
+}
+
+// Index returns the frame of the given index, and whether it exists
+func (t *Track) Index(n int) (int, bool) {
+	for _, i := range t.Indexes {
+		if i.Number == n {
+			return i.Frame, true
+		}
+	}
+	return 0, false
+}
+
+// Start returns the frame at which the track starts within its file, which
+// is INDEX 01, falling back to the first index
+func (t *Track) Start() int {
+	if f, ok := t.Index(1); ok {
+		return f
+	}
+	if len(t.Indexes) > 0 {
+		return t.Indexes[0].Frame
+	}
+	return 0
+}
+
+// first returns the frame of the first index, including any pregap stored
+// in the file as INDEX 00
+func (t *Track) first() int {
+	if len(t.Indexes) > 0 {
+		return t.Indexes[0].Frame
+	}
+	return 0
+}
+
+// Offset returns the byte offset within the file of the given frame of the
+// track. Tracks in the same file can have different sector sizes so the
+// size of every preceding track is taken into account, as is anything
+// stored before the first track's first index
+func (f *File) Offset(t *Track, frame int) (int64, error) {
+	var offset int64
+	for i, track := range f.Tracks {
+		size := track.SectorSize()
+		if size == 0 {
+			return 0, fmt.Errorf("cue: unsupported track mode \"%s\"", track.Mode)
+		}
+		if i == 0 {
+			offset = int64(track.first()) * int64(size)
+		}
+		if track == t {
+			return offset + int64(frame-track.first())*int64(size), nil
+		}
+		if i+1 < len(f.Tracks) {
+			offset += int64(f.Tracks[i+1].first()-track.first()) * int64(size)
+		}
+	}
+	return 0, errors.New("cue: track not in file")
+}
+
+// parseTime parses a time in the form mm:ss:ff into a number of frames
+func parseTime(s string) (int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid time \"%s\"", s)
+	}
+
+	var v [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid time \"%s\"", s)
+		}
+		v[i] = n
+	}
+	if v[1] >= 60 || v[2] >= FramesPerSecond {
+		return 0, fmt.Errorf("invalid time \"%s\"", s)
+	}
+
+	return (v[0]*60+v[1])*FramesPerSecond + v[2], nil
+}
+
+// fields splits a line into its fields, keeping any quoted string intact
+func fields(line string) ([]string, error) {
+	var f []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return f, nil
+		}
+
+		if line[0] == '"' {
+			i := strings.IndexByte(line[1:], '"')
+			if i < 0 {
+				return nil, errors.New("unterminated string")
+			}
+			f = append(f, line[1:i+1])
+			line = line[i+2:]
+			continue
+		}
+
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			i = len(line)
+		}
+		f = append(f, line[:i])
+		line = line[i:]
+	}
+}
+
+// Parse parses a cue sheet
+func Parse(r io.Reader) (*Sheet, error) {
+	sheet := new(Sheet)
+
+	var file *File
+	var track *Track
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if n == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+
+		f, err := fields(strings.TrimSpace(line))
+		if err != nil {
+			return nil, fmt.Errorf("cue: line %d: %v", n, err)
+		}
+		if len(f) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(f[0]) {
+		case "FILE":
+			if len(f) < 2 {
+				return nil, fmt.Errorf("cue: line %d: missing file name", n)
+			}
+			file = &File{Name: f[1], Line: n}
+			if len(f) > 2 {
+				file.Type = strings.ToUpper(f[2])
+			}
+			sheet.Files = append(sheet.Files, file)
+			track = nil
+		case "TRACK":
+			if file == nil {
+				return nil, fmt.Errorf("cue: line %d: TRACK before FILE", n)
+			}
+			if len(f) < 3 {
+				return nil, fmt.Errorf("cue: line %d: invalid TRACK", n)
+			}
+			number, err := strconv.Atoi(f[1])
+			if err != nil {
+				return nil, fmt.Errorf("cue: line %d: invalid track number \"%s\"", n, f[1])
+			}
+			track = &Track{Number: number, Mode: strings.ToUpper(f[2]), Line: n}
+			file.Tracks = append(file.Tracks, track)
+		case "INDEX":
+			if track == nil {
+				return nil, fmt.Errorf("cue: line %d: INDEX before TRACK", n)
+			}
+			if len(f) < 3 {
+				return nil, fmt.Errorf("cue: line %d: invalid INDEX", n)
+			}
+			number, err := strconv.Atoi(f[1])
+			if err != nil {
+				return nil, fmt.Errorf("cue: line %d: invalid index number \"%s\"", n, f[1])
+			}
+			frame, err := parseTime(f[2])
+			if err != nil {
+				return nil, fmt.Errorf("cue: line %d: %v", n, err)
+			}
+			track.Indexes = append(track.Indexes, Index{number, frame})
+		case "PREGAP", "POSTGAP":
+			if track == nil {
+				return nil, fmt.Errorf("cue: line %d: %s before TRACK", n, strings.ToUpper(f[0]))
+			}
+			if len(f) < 2 {
+				return nil, fmt.Errorf("cue: line %d: missing time", n)
+			}
+			frames, err := parseTime(f[1])
+			if err != nil {
+				return nil, fmt.Errorf("cue: line %d: %v", n, err)
+			}
+			if strings.EqualFold(f[0], "PREGAP") {
+				track.Pregap = frames
+			} else {
+				track.Postgap = frames
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return sheet, nil
+}
+
+// ParseFile parses the cue sheet in the given file
+func ParseFile(file string) (*Sheet, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// FirstDataTrack returns the first data track and the file containing it
+func (s *Sheet) FirstDataTrack() (*File, *Track, bool) {
+	for _, f := range s.Files {
+		for _, t := range f.Tracks {
+			if t.IsData() {
+				return f, t, true
+			}
+		}
+	}
+	return nil, nil, false
+}
+
+// FormatTime formats a number of frames as a time in the form mm:ss:ff
+func FormatTime(frames int) string {
+	return fmt.Sprintf("%02d:%02d:%02d", frames/FramesPerSecond/60, frames/FramesPerSecond%60, frames%FramesPerSecond)
+}
+
+// WriteTo writes the cue sheet to w
+func (s *Sheet) WriteTo(w io.Writer) (int64, error) {
+	b := new(strings.Builder)
+	for _, f := range s.Files {
+		fmt.Fprintf(b, "FILE \"%s\" %s\r\n", f.Name, f.Type)
+		for _, t := range f.Tracks {
+			fmt.Fprintf(b, "  TRACK %02d %s\r\n", t.Number, t.Mode)
+			if t.Pregap > 0 {
+				fmt.Fprintf(b, "    PREGAP %s\r\n", FormatTime(t.Pregap))
+			}
+			for _, i := range t.Indexes {
+				fmt.Fprintf(b, "    INDEX %02d %s\r\n", i.Number, FormatTime(i.Frame))
+			}
+			if t.Postgap > 0 {
+				fmt.Fprintf(b, "    POSTGAP %s\r\n", FormatTime(t.Postgap))
+			}
+		}
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
@@ -0,0 +1,118 @@
+package cue
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const sheet = "\xef\xbb\xbfREM COMMENT \"Mixed\"\r\n" + `FILE "Game (Track 1).bin" BINARY
+  TRACK 01 AUDIO
+    INDEX 01 00:00:00
+  TRACK 02 MODE1/2048
+    INDEX 00 00:02:00
+    INDEX 01 00:04:00
+FILE "Game (Track 3).ogg" OGG
+  TRACK 03 AUDIO
+    PREGAP 00:02:00
+    INDEX 01 00:00:00
+`
+
+func TestParse(t *testing.T) {
+	s, err := Parse(strings.NewReader(sheet))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if assert.Len(t, s.Files, 2) {
+		assert.Equal(t, "Game (Track 1).bin", s.Files[0].Name)
+		assert.Equal(t, "BINARY", s.Files[0].Type)
+		assert.Equal(t, "OGG", s.Files[1].Type)
+		assert.Equal(t, 2*FramesPerSecond, s.Files[1].Tracks[0].Pregap)
+	}
+
+	f, track, ok := s.FirstDataTrack()
+	if assert.True(t, ok) {
+		assert.Equal(t, 2, track.Number)
+		assert.Equal(t, 2048, track.SectorSize())
+		assert.Equal(t, 4*FramesPerSecond, track.Start())
+
+		offset, err := f.Offset(track, track.Start())
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2*FramesPerSecond*2352+2*FramesPerSecond*2048), offset)
+	}
+}
+
+func TestOffset(t *testing.T) {
+	tables := []struct {
+		sheet  string
+		track  int
+		offset int64
+	}{
+		{
+			"FILE \"a.bin\" BINARY\nTRACK 01 MODE1/2352\nINDEX 01 00:00:00\n",
+			0,
+			0,
+		},
+		{
+			"FILE \"a.bin\" BINARY\nTRACK 01 MODE1/2352\nINDEX 01 00:02:00\n",
+			0,
+			2 * FramesPerSecond * 2352,
+		},
+		{
+			"FILE \"a.bin\" BINARY\nTRACK 01 MODE1/2048\nINDEX 01 00:02:00\nTRACK 02 AUDIO\nINDEX 00 00:04:00\nINDEX 01 00:06:00\n",
+			1,
+			4*FramesPerSecond*2048 + 2*FramesPerSecond*2352,
+		},
+	}
+
+	for _, table := range tables {
+		s, err := Parse(strings.NewReader(table.sheet))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := s.Files[0]
+		track := f.Tracks[table.track]
+		offset, err := f.Offset(track, track.Start())
+		assert.Nil(t, err)
+		assert.Equal(t, table.offset, offset, table.sheet)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{
+		"TRACK 01 AUDIO",
+		"FILE \"a.bin\" BINARY\nINDEX 01 00:00:00",
+		"FILE \"a.bin\" BINARY\nTRACK 01 AUDIO\nINDEX 01 00:60:00",
+		"FILE \"a.bin",
+	} {
+		_, err := Parse(strings.NewReader(s))
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	s, err := Parse(strings.NewReader(sheet))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := new(strings.Builder)
+	if _, err := s.WriteTo(b); err != nil {
+		t.Fatal(err)
+	}
+
+	s2, err := Parse(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b2 := new(strings.Builder)
+	if _, err := s2.WriteTo(b2); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, b.String(), b2.String())
+	assert.Equal(t, len(s.Files), len(s2.Files))
+	assert.Equal(t, "01:02:03", FormatTime((60+2)*FramesPerSecond+3))
+}
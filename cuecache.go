@@ -0,0 +1,178 @@
+package megasd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bodgit/megasd/cue"
+	"github.com/bodgit/megasd/rom"
+)
+
+// cueDir is what the cue sheets in a single directory say about its files
+type cueDir struct {
+	dir    string
+	files  []string     // Every regular, non-hidden file, sorted
+	cues   []string     // Every cue sheet, sorted
+	sheets []*cue.Sheet // Each cue sheet in the same order, nil if it can't be parsed
+	tracks map[string]struct{}
+	data   bool // Whether any cue sheet has a data track
+}
+
+func readCueDir(dir string) (*cueDir, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &cueDir{
+		dir:    dir,
+		tracks: make(map[string]struct{}),
+	}
+	for _, info := range files {
+		if !info.Mode().IsRegular() || info.Name()[0] == '.' {
+			continue
+		}
+		d.files = append(d.files, info.Name())
+
+		if !isCue(info.Name()) {
+			continue
+		}
+		d.cues = append(d.cues, info.Name())
+
+		// A cue sheet that can't be parsed doesn't claim any tracks
+		sheet, _ := cue.ParseFile(filepath.Join(dir, info.Name()))
+		d.sheets = append(d.sheets, sheet)
+		if sheet == nil {
+			continue
+		}
+		for _, f := range sheet.Files {
+			d.tracks[trackName(f.Name)] = struct{}{}
+		}
+		if _, _, err := firstDataTrack(sheet); err == nil {
+			d.data = true
+		}
+	}
+
+	return d, nil
+}
+
+// trackName returns the lower-cased base name of a file referenced by a cue
+// sheet, which may use either path separator
+func trackName(name string) string {
+	return strings.ToLower(filepath.Base(filepath.FromSlash(strings.Replace(name, "\\", "/", -1))))
+}
+
+// isTrack reports whether the file is referenced as a track by any cue
+// sheet in the directory, ignoring case as the firmware does
+func (d *cueDir) isTrack(file string) bool {
+	_, ok := d.tracks[strings.ToLower(filepath.Base(file))]
+	return ok
+}
+
+// companions returns the other files in the directory that belong to the
+// loose game file, which are the tracks of its cue sheet and any file with
+// the same name but a different extension, such as a save
+func (d *cueDir) companions(file string) []string {
+	file = filepath.Base(file)
+	name := strings.TrimSuffix(file, filepath.Ext(file))
+
+	tracks := make(map[string]struct{})
+	for i, c := range d.cues {
+		if c != file || d.sheets[i] == nil {
+			continue
+		}
+		for _, f := range d.sheets[i].Files {
+			tracks[trackName(f.Name)] = struct{}{}
+		}
+	}
+
+	var files []string
+	for _, f := range d.files {
+		if f == file {
+			continue
+		}
+		if _, ok := tracks[strings.ToLower(f)]; ok || strings.TrimSuffix(f, filepath.Ext(f)) == name {
+			files = append(files, filepath.Join(d.dir, f))
+		}
+	}
+
+	return files
+}
+
+// first returns the first file belonging to a system that keeps each game
+// in its own directory. A cue sheet is preferred over anything else, such
+// as an ISO image, as that is likely one of its tracks
+func (d *cueDir) first() (string, *System) {
+	if len(d.cues) > 0 {
+		return filepath.Join(d.dir, d.cues[0]), lookupSystem(d.cues[0])
+	}
+	for _, file := range d.files {
+		if s := lookupSystem(file); s != nil && s.Layout == LayoutDirectory {
+			return filepath.Join(d.dir, file), s
+		}
+	}
+	return "", nil
+}
+
+// msuROM returns the ROM image of an MSU-MD game, which is a Mega Drive ROM
+// image alongside a cue sheet with only audio tracks. The ROM image is the
+// one not referenced by the cue sheet, so it can also have a .bin extension.
+// An empty string is returned if the directory isn't an MSU-MD game
+func (d *cueDir) msuROM() (string, *System) {
+	if len(d.cues) == 0 || d.data {
+		return "", nil
+	}
+	for _, sheet := range d.sheets {
+		if sheet == nil {
+			return "", nil
+		}
+	}
+
+	for _, file := range d.files {
+		if d.isTrack(file) {
+			continue
+		}
+		if s := lookupSystem(file); s != nil && s.System == rom.SystemMegaDrive {
+			return filepath.Join(d.dir, file), s
+		}
+	}
+
+	return "", nil
+}
+
+// cueCache parses the cue sheets in each directory only once
+type cueCache struct {
+	mu   sync.Mutex
+	dirs map[string]*cueDir
+}
+
+func newCueCache() *cueCache {
+	return &cueCache{
+		dirs: make(map[string]*cueDir),
+	}
+}
+
+// dir returns the parsed cue sheets in the directory
+func (c *cueCache) dir(dir string) (*cueDir, error) {
+	c.mu.Lock()
+	d, ok := c.dirs[dir]
+	c.mu.Unlock()
+	if ok {
+		return d, nil
+	}
+
+	// Parse without holding the lock, at worst another worker parses the
+	// same directory at the same time
+	d, err := readCueDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.dirs[dir] = d
+	c.mu.Unlock()
+
+	return d, nil
+}
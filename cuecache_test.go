@@ -0,0 +1,105 @@
+package megasd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bodgit/megasd/metadata"
+	"github.com/bodgit/megasd/rom"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCueDir(t *testing.T) {
+	d, err := readCueDir(filepath.Join("testdata", "scan"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, d.data)
+	assert.True(t, d.isTrack("Disc.bin"))
+	assert.False(t, d.isTrack("ROM.bin"))
+}
+
+// scanTestdata runs every file in dir through fileWorker the same way as a
+// scan, after adding a game matching each of the given files
+func scanTestdata(t *testing.T, dir string, games ...string) *metadata.DB {
+	m, cleanup := newTestMegaSD(t)
+	defer cleanup()
+
+	for _, name := range games {
+		file := filepath.Join(dir, name)
+		crc, err := lookupSystem(file).Hash(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := addNamedGame(t, m, name, rom.SystemUnknown)
+		if err := m.db.addChecksum(id, crc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db := metadata.New()
+	o := &scanOptions{
+		cues:  newCueCache(),
+		names: new(gameNames),
+	}
+	if err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		s := lookupSystem(file)
+		if !info.Mode().IsRegular() || s == nil {
+			return nil
+		}
+		return m.fileWorker(dir, file, s, db, o, new(Report))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestFileWorkerTracks(t *testing.T) {
+	// Both would match, but only the loose ROM image is a game
+	db := scanTestdata(t, filepath.Join("testdata", "scan"), "ROM.bin", "Disc.bin")
+
+	_, ok := db.Get(metadata.CRCFilename("ROM"))
+	assert.True(t, ok)
+	_, ok = db.Get(metadata.CRCFilename("Disc"))
+	assert.False(t, ok)
+	assert.Equal(t, 1, db.Length())
+}
+
+func TestMSUROM(t *testing.T) {
+	tables := []struct {
+		dir string
+		rom string
+	}{
+		{filepath.Join("testdata", "scan", "Sonic MSU"), filepath.Join("testdata", "scan", "Sonic MSU", "Sonic MSU.bin")},
+		// A cue sheet with a data track is a CD, whatever else is there
+		{filepath.Join("testdata", "scan"), ""},
+	}
+
+	for _, table := range tables {
+		d, err := readCueDir(table.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		file, s := d.msuROM()
+		assert.Equal(t, table.rom, file, table.dir)
+		if table.rom != "" && assert.NotNil(t, s, table.dir) {
+			assert.Equal(t, rom.SystemMegaDrive, s.System, table.dir)
+		}
+	}
+}
+
+func TestFileWorkerMSU(t *testing.T) {
+	db := scanTestdata(t, filepath.Join("testdata", "scan"), filepath.Join("Sonic MSU", "Sonic MSU.bin"))
+
+	// The screenshot is stored under the name of the directory
+	_, ok := db.Get(metadata.CRCFilename("Sonic MSU"))
+	assert.True(t, ok)
+	assert.Equal(t, 1, db.Length())
+}
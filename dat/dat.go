@@ -0,0 +1,246 @@
+/*
+Package dat parses the DAT files used by ROM managers to describe a set of
+games and the checksums of their files.
+
+Both the Logiqx XML format, as used by No-Intro and Redump, and the older
+clrmamepro format are supported.
+*/
+package dat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// File is a parsed DAT file
+type File struct {
+	Name        string
+	Description string
+	Version     string
+	Games       []Game
+}
+
+// Game is a single game within a DAT file
+type Game struct {
+	Name        string
+	Description string
+	ROMs        []ROM
+}
+
+// ROM is a single file belonging to a game. The checksums are upper-case
+// hexadecimal strings and may be empty if the DAT file doesn't provide them
+type ROM struct {
+	Name string
+	Size int64
+	CRC  string
+	MD5  string
+	SHA1 string
+}
+
+// Parse reads a DAT file in either Logiqx XML or clrmamepro format
+func Parse(r io.Reader) (*File, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("<")) {
+		return parseXML(b)
+	}
+	return parseClrMamePro(b)
+}
+
+type xmlDatafile struct {
+	XMLName xml.Name `xml:"datafile"`
+	Header  struct {
+		Name        string `xml:"name"`
+		Description string `xml:"description"`
+		Version     string `xml:"version"`
+	} `xml:"header"`
+	Games []struct {
+		Name        string `xml:"name,attr"`
+		Description string `xml:"description"`
+		ROMs        []struct {
+			Name string `xml:"name,attr"`
+			Size int64  `xml:"size,attr"`
+			CRC  string `xml:"crc,attr"`
+			MD5  string `xml:"md5,attr"`
+			SHA1 string `xml:"sha1,attr"`
+		} `xml:"rom"`
+	} `xml:"game"`
+}
+
+func parseXML(b []byte) (*File, error) {
+	var x xmlDatafile
+	if err := xml.Unmarshal(b, &x); err != nil {
+		return nil, err
+	}
+
+	f := &File{
+		Name:        x.Header.Name,
+		Description: x.Header.Description,
+		Version:     x.Header.Version,
+	}
+
+	for _, xg := range x.Games {
+		g := Game{
+			Name:        xg.Name,
+			Description: xg.Description,
+		}
+		for _, xr := range xg.ROMs {
+			g.ROMs = append(g.ROMs, ROM{
+				Name: xr.Name,
+				Size: xr.Size,
+				CRC:  strings.ToUpper(xr.CRC),
+				MD5:  strings.ToUpper(xr.MD5),
+				SHA1: strings.ToUpper(xr.SHA1),
+			})
+		}
+		f.Games = append(f.Games, g)
+	}
+
+	return f, nil
+}
+
+// node is either a key with a string value, or a key with a list of child
+// nodes when the value is a parenthesised block
+type node struct {
+	key      string
+	value    string
+	children []node
+}
+
+func (n node) get(key string) string {
+	for _, c := range n.children {
+		if c.key == key {
+			return c.value
+		}
+	}
+	return ""
+}
+
+var errSyntax = errors.New("dat: syntax error")
+
+func tokenize(b []byte) ([]string, error) {
+	var tokens []string
+	s := bufio.NewScanner(bytes.NewReader(b))
+	s.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		i := 0
+		for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\r' || data[i] == '\n') {
+			i++
+		}
+		if i == len(data) {
+			return i, nil, nil
+		}
+
+		switch data[i] {
+		case '(', ')':
+			return i + 1, data[i : i+1], nil
+		case '"':
+			if j := bytes.IndexByte(data[i+1:], '"'); j >= 0 {
+				// Keep the leading quote so an empty string
+				// is still a token
+				return i + j + 2, data[i : i+j+1], nil
+			}
+			if atEOF {
+				return 0, nil, errSyntax
+			}
+			return i, nil, nil
+		}
+
+		for j := i; j < len(data); j++ {
+			switch data[j] {
+			case ' ', '\t', '\r', '\n', '(', ')':
+				return j, data[i:j], nil
+			}
+		}
+		if atEOF {
+			return len(data), data[i:], nil
+		}
+		return i, nil, nil
+	})
+	for s.Scan() {
+		tokens = append(tokens, s.Text())
+	}
+	return tokens, s.Err()
+}
+
+func parseNodes(tokens []string) ([]node, []string, error) {
+	var nodes []node
+	for len(tokens) > 0 {
+		if tokens[0] == ")" {
+			return nodes, tokens, nil
+		}
+		if len(tokens) < 2 || tokens[0] == "(" {
+			return nil, nil, errSyntax
+		}
+
+		n := node{key: tokens[0]}
+		if tokens[1] == "(" {
+			children, rest, err := parseNodes(tokens[2:])
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(rest) == 0 {
+				return nil, nil, errSyntax
+			}
+			n.children, tokens = children, rest[1:]
+		} else {
+			n.value, tokens = strings.TrimPrefix(tokens[1], "\""), tokens[2:]
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, tokens, nil
+}
+
+func parseClrMamePro(b []byte) (*File, error) {
+	tokens, err := tokenize(b)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, rest, err := parseNodes(tokens)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errSyntax
+	}
+
+	f := new(File)
+	for _, n := range nodes {
+		switch n.key {
+		case "clrmamepro":
+			f.Name = n.get("name")
+			f.Description = n.get("description")
+			f.Version = n.get("version")
+		case "game":
+			g := Game{
+				Name:        n.get("name"),
+				Description: n.get("description"),
+			}
+			for _, c := range n.children {
+				if c.key != "rom" {
+					continue
+				}
+				size, _ := strconv.ParseInt(c.get("size"), 10, 64)
+				g.ROMs = append(g.ROMs, ROM{
+					Name: c.get("name"),
+					Size: size,
+					CRC:  strings.ToUpper(c.get("crc")),
+					MD5:  strings.ToUpper(c.get("md5")),
+					SHA1: strings.ToUpper(c.get("sha1")),
+				})
+			}
+			f.Games = append(f.Games, g)
+		}
+	}
+
+	return f, nil
+}
@@ -0,0 +1,80 @@
+package dat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const clrMamePro = `clrmamepro (
+	name "Sega - Mega Drive - Genesis"
+	description "Sega - Mega Drive - Genesis"
+	version 20191212-064302
+)
+
+game (
+	name "Sonic The Hedgehog (USA, Europe)"
+	description "Sonic The Hedgehog (USA, Europe)"
+	rom ( name "Sonic The Hedgehog (USA, Europe).md" size 524288 crc f9394e97 md5 1bc674be034e43c96b86487ac69d9293 sha1 6ddb7de1e17e7f6cdb88927bd906352030daa194 )
+)
+`
+
+const logiqx = `<?xml version="1.0"?>
+<!DOCTYPE datafile PUBLIC "-//Logiqx//DTD ROM Management Datafile//EN" "http://www.logiqx.com/dtds/datafile.dtd">
+<datafile>
+	<header>
+		<name>Sega - Mega Drive - Genesis</name>
+		<description>Sega - Mega Drive - Genesis</description>
+		<version>20191212-064302</version>
+	</header>
+	<game name="Sonic The Hedgehog (USA, Europe)">
+		<description>Sonic The Hedgehog (USA, Europe)</description>
+		<rom name="Sonic The Hedgehog (USA, Europe).md" size="524288" crc="f9394e97" md5="1bc674be034e43c96b86487ac69d9293" sha1="6ddb7de1e17e7f6cdb88927bd906352030daa194"/>
+	</game>
+</datafile>
+`
+
+func TestParse(t *testing.T) {
+	for _, s := range []string{clrMamePro, logiqx} {
+		f, err := Parse(strings.NewReader(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, "Sega - Mega Drive - Genesis", f.Name)
+		assert.Equal(t, "20191212-064302", f.Version)
+		assert.Equal(t, []Game{
+			{
+				Name:        "Sonic The Hedgehog (USA, Europe)",
+				Description: "Sonic The Hedgehog (USA, Europe)",
+				ROMs: []ROM{
+					{
+						Name: "Sonic The Hedgehog (USA, Europe).md",
+						Size: 524288,
+						CRC:  "F9394E97",
+						MD5:  "1BC674BE034E43C96B86487AC69D9293",
+						SHA1: "6DDB7DE1E17E7F6CDB88927BD906352030DAA194",
+					},
+				},
+			},
+		}, f.Games)
+	}
+}
+
+func TestParseName(t *testing.T) {
+	tables := []struct {
+		name string
+		want Name
+	}{
+		{"Sonic The Hedgehog", Name{Title: "Sonic The Hedgehog"}},
+		{"Sonic The Hedgehog 2 (World) (Rev A)", Name{Title: "Sonic The Hedgehog 2", Regions: []string{"World"}, Revision: "A"}},
+		{"Phantasy Star II (USA, Europe) (Rev 1) [b]", Name{Title: "Phantasy Star II", Regions: []string{"USA", "Europe"}, Revision: "1", Tags: []string{"[b]"}}},
+		{"Snatcher (USA) (Disc 1)", Name{Title: "Snatcher", Regions: []string{"USA"}, Tags: []string{"(Disc 1)"}}},
+		{"Zool (Europe) (En,Fr,De) (v1.1)", Name{Title: "Zool", Regions: []string{"Europe"}, Revision: "v1.1", Tags: []string{"(En,Fr,De)"}}},
+	}
+
+	for _, table := range tables {
+		assert.Equal(t, table.want, ParseName(table.name), table.name)
+	}
+}
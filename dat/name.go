@@ -0,0 +1,89 @@
+package dat
+
+import (
+	"strings"
+)
+
+var regions = map[string]struct{}{
+	"Asia":           {},
+	"Australia":      {},
+	"Brazil":         {},
+	"Canada":         {},
+	"China":          {},
+	"Europe":         {},
+	"France":         {},
+	"Germany":        {},
+	"Hong Kong":      {},
+	"Italy":          {},
+	"Japan":          {},
+	"Korea":          {},
+	"Netherlands":    {},
+	"Russia":         {},
+	"Spain":          {},
+	"Sweden":         {},
+	"Taiwan":         {},
+	"United Kingdom": {},
+	"USA":            {},
+	"World":          {},
+}
+
+// Name is a game name split into the title and the tags that follow it,
+// following the No-Intro and Redump naming conventions, for example
+// "Sonic The Hedgehog 2 (World) (Rev A)"
+type Name struct {
+	Title    string
+	Regions  []string
+	Revision string
+	Tags     []string // Any other tags, including their brackets
+}
+
+// ParseName splits a game name into its title and tags
+func ParseName(s string) Name {
+	var n Name
+
+	i := strings.IndexAny(s, "([")
+	if i < 0 {
+		n.Title = strings.TrimSpace(s)
+		return n
+	}
+	n.Title = strings.TrimSpace(s[:i])
+
+	for s = s[i:]; s != ""; {
+		end := strings.IndexByte(s, map[byte]byte{'(': ')', '[': ']'}[s[0]])
+		if end < 0 {
+			n.Tags = append(n.Tags, strings.TrimSpace(s))
+			break
+		}
+
+		tag := s[:end+1]
+		inner := tag[1 : len(tag)-1]
+		switch {
+		case tag[0] == '(' && n.Regions == nil && isRegions(inner):
+			n.Regions = strings.Split(inner, ", ")
+		case tag[0] == '(' && n.Revision == "" && strings.HasPrefix(inner, "Rev "):
+			n.Revision = strings.TrimPrefix(inner, "Rev ")
+		case tag[0] == '(' && n.Revision == "" && len(inner) > 1 && inner[0] == 'v' && inner[1] >= '0' && inner[1] <= '9':
+			n.Revision = inner
+		default:
+			n.Tags = append(n.Tags, tag)
+		}
+
+		s = strings.TrimLeft(s[end+1:], " ")
+		if s != "" && s[0] != '(' && s[0] != '[' {
+			// Trailing text that isn't a tag
+			n.Tags = append(n.Tags, s)
+			break
+		}
+	}
+
+	return n
+}
+
+func isRegions(s string) bool {
+	for _, r := range strings.Split(s, ", ") {
+		if _, ok := regions[r]; !ok {
+			return false
+		}
+	}
+	return true
+}
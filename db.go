@@ -16,6 +16,7 @@ import (
 
 	"github.com/bodgit/megasd/image"
 	"github.com/bodgit/megasd/metadata"
+	"github.com/bodgit/megasd/rom"
 
 	// Database driver
 	_ "github.com/mattn/go-sqlite3"
@@ -44,7 +45,49 @@ func newGameDB(file string) (*gameDB, error) {
 		return nil, err
 	}
 
-	if _, err = db.Exec("CREATE TABLE IF NOT EXISTS checksum(game_id INTEGER NOT NULL, crc TEXT NOT NULL UNIQUE, FOREIGN KEY(game_id) REFERENCES game(id))"); err != nil {
+	if _, err = db.Exec("CREATE TABLE IF NOT EXISTS checksum(game_id INTEGER NOT NULL, crc TEXT NOT NULL UNIQUE, disc INTEGER, FOREIGN KEY(game_id) REFERENCES game(id))"); err != nil {
+		return nil, err
+	}
+
+	// Databases created before multi-disc games were modelled lack the column
+	if err = addColumn(db, "checksum", "disc", "INTEGER"); err != nil {
+		return nil, err
+	}
+
+	// Databases created before serials were qualified by their system need
+	// the table rebuilding to change its unique constraint
+	if err = migrateSerial(db); err != nil {
+		return nil, err
+	}
+
+	if _, err = db.Exec("CREATE TABLE IF NOT EXISTS serial (game_id INTEGER NOT NULL, serial TEXT NOT NULL, region TEXT NOT NULL, system TEXT NOT NULL DEFAULT '', UNIQUE(serial, region, system), FOREIGN KEY(game_id) REFERENCES game(id))"); err != nil {
+		return nil, err
+	}
+
+	if _, err = db.Exec("CREATE TABLE IF NOT EXISTS dat (id INTEGER PRIMARY KEY NOT NULL, system TEXT NOT NULL, name TEXT NOT NULL, region TEXT NOT NULL, revision TEXT NOT NULL, file TEXT NOT NULL, size INTEGER NOT NULL, crc TEXT NOT NULL, md5 TEXT NOT NULL, sha1 TEXT NOT NULL, game_id INTEGER, FOREIGN KEY(game_id) REFERENCES game(id))"); err != nil {
+		return nil, err
+	}
+
+	if _, err = db.Exec("CREATE INDEX IF NOT EXISTS dat_crc ON dat (crc)"); err != nil {
+		return nil, err
+	}
+
+	if _, err = db.Exec("CREATE INDEX IF NOT EXISTS dat_sha1 ON dat (sha1)"); err != nil {
+		return nil, err
+	}
+
+	// Track hashes used to be keyed by the CRC of the first data sector,
+	// which revisions of the same disc can share. They can be hashed again
+	// so the old table is simply dropped
+	if exists, ok, err := hasColumn(db, "track_hash", "crc"); err != nil {
+		return nil, err
+	} else if exists && ok {
+		if _, err = db.Exec("DROP TABLE track_hash"); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err = db.Exec("CREATE TABLE IF NOT EXISTS track_hash (sha1 TEXT NOT NULL UNIQUE, size INTEGER NOT NULL, track_crc TEXT NOT NULL, md5 TEXT NOT NULL, dat_id INTEGER, FOREIGN KEY(dat_id) REFERENCES dat(id))"); err != nil {
 		return nil, err
 	}
 
@@ -53,6 +96,71 @@ func newGameDB(file string) (*gameDB, error) {
 	}, nil
 }
 
+// hasColumn reports whether the table exists and has the column
+func hasColumn(db *sql.DB, table, column string) (bool, bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, false, err
+	}
+	defer rows.Close()
+
+	exists := false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, kind string
+		var value sql.NullString
+		if err := rows.Scan(&cid, &name, &kind, &notNull, &value, &pk); err != nil {
+			return false, false, err
+		}
+		exists = true
+		if name == column {
+			return true, true, nil
+		}
+	}
+
+	return exists, false, rows.Err()
+}
+
+// addColumn adds the column to an existing table if it doesn't already have it
+func addColumn(db *sql.DB, table, column, definition string) error {
+	_, ok, err := hasColumn(db, table, column)
+	if err != nil || ok {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// migrateSerial renames a serial table without a system column out of the
+// way and copies its rows into the new table, with an empty system as it
+// isn't known
+func migrateSerial(db *sql.DB) error {
+	exists, ok, err := hasColumn(db, "serial", "system")
+	if err != nil || !exists || ok {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		"ALTER TABLE serial RENAME TO serial_old",
+		"CREATE TABLE serial (game_id INTEGER NOT NULL, serial TEXT NOT NULL, region TEXT NOT NULL, system TEXT NOT NULL DEFAULT '', UNIQUE(serial, region, system), FOREIGN KEY(game_id) REFERENCES game(id))",
+		"INSERT INTO serial (game_id, serial, region) SELECT game_id, serial, region FROM serial_old",
+		"DROP TABLE serial_old",
+	} {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 type xmlGameDB struct {
 	XMLName   xml.Name      `xml:"GameDB"`
 	Games     []xmlGame     `xml:"Game"`
@@ -102,6 +210,14 @@ func (db *gameDB) ImportXML(file string) error {
 		return err
 	}
 
+	if _, err = db.db.Exec("DELETE FROM serial"); err != nil {
+		return err
+	}
+
+	if _, err = db.db.Exec("UPDATE dat SET game_id = NULL"); err != nil {
+		return err
+	}
+
 	if _, err = db.db.Exec("DELETE FROM game"); err != nil {
 		return err
 	}
@@ -165,14 +281,42 @@ func (db *gameDB) addScreenshot(file string) (int64, error) {
 	}
 	sha := fmt.Sprintf("%X", h.Sum(nil))
 
+	return db.insertScreenshot(sha, func() ([]byte, error) {
+		b := new(bytes.Buffer)
+		if err := image.Encode(b, m); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	})
+}
+
+// addScreenshotData adds a screenshot taken from an existing metadata
+// database. It is stored as-is minus the genre and year information
+func (db *gameDB) addScreenshotData(screenshot []byte) (int64, error) {
+	if _, err := image.DecodeLenient(bytes.NewReader(screenshot)); err != nil {
+		return 0, err
+	}
+
+	data := make([]byte, len(screenshot))
+	copy(data, screenshot)
+	metadata.ClearInfo(data)
+
+	sha := fmt.Sprintf("%X", sha1.Sum(data))
+
+	return db.insertScreenshot(sha, func() ([]byte, error) {
+		return data, nil
+	})
+}
+
+func (db *gameDB) insertScreenshot(sha string, data func() ([]byte, error)) (int64, error) {
 	var id int64
 	switch err := db.db.QueryRow("SELECT id FROM screenshot WHERE sha1 = ?", sha).Scan(&id); err {
 	case sql.ErrNoRows:
-		b := new(bytes.Buffer)
-		if err := image.Encode(b, m); err != nil {
+		b, err := data()
+		if err != nil {
 			return 0, err
 		}
-		result, err := db.db.Exec("INSERT INTO screenshot (sha1, data) VALUES (?, ?)", sha, b.Bytes())
+		result, err := db.db.Exec("INSERT INTO screenshot (sha1, data) VALUES (?, ?)", sha, b)
 		if err != nil {
 			return 0, err
 		}
@@ -201,40 +345,137 @@ func (db *gameDB) addGame(name string, year, genre, screenshot sql.NullInt64) (i
 }
 
 func (db *gameDB) addChecksum(game int64, crc string) error {
-	if _, err := db.db.Exec("INSERT OR REPLACE INTO checksum (game_id, crc) VALUES (?, ?)", game, crc); err != nil {
+	if _, err := db.db.Exec("INSERT INTO checksum (game_id, crc) VALUES (?, ?) ON CONFLICT(crc) DO UPDATE SET game_id = excluded.game_id", game, crc); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (db *gameDB) FindScreenshotByCRC(crc string) ([]byte, error) {
-	var year, genre sql.NullInt64
+// setDisc records which disc of a multi-disc game the CRC belongs to, if it
+// isn't already known
+func (db *gameDB) setDisc(crc string, disc int) error {
+	if _, err := db.db.Exec("UPDATE checksum SET disc = ? WHERE crc = ? AND disc IS NULL", disc, crc); err != nil {
+		return err
+	}
+	return nil
+}
+
+// gameMatch is a game found in the database along with its screenshot,
+// ready to be stored in a metadata database
+type gameMatch struct {
+	id         int64
+	name       string
+	screenshot []byte
+	disc       int  // Disc number of a multi-disc game, zero if unknown
+	system     bool // Whether a name match is known to be for the system
+}
+
+func (db *gameDB) findGame(query string, args ...interface{}) (*gameMatch, error) {
+	var year, genre, disc sql.NullInt64
 	var data []byte
-	switch err := db.db.QueryRow("SELECT g.year, g.genre, s.data FROM checksum AS c JOIN game AS g ON c.game_id = g.id LEFT JOIN screenshot AS s ON g.screenshot_id = s.id WHERE c.crc = ?", crc).Scan(&year, &genre, &data); err {
+	var g gameMatch
+	switch err := db.db.QueryRow(query, args...).Scan(&g.id, &g.name, &year, &genre, &data, &disc); err {
 	case sql.ErrNoRows:
 		return nil, nil
 	case nil:
-		if data == nil {
-			return nil, nil
+		g.disc = int(disc.Int64)
+		if data != nil {
+			var screenshot [metadata.ScreenshotSize]byte
+			copy(screenshot[:], data)
+
+			// XXX Should only enable this if there is a genre and/or year?
+			metadata.SetInfo(screenshot[:], int(genre.Int64), int(year.Int64))
+
+			g.screenshot = screenshot[:]
 		}
+		return &g, nil
+	default:
+		return nil, err
+	}
+}
 
-		var screenshot [metadata.ScreenshotSize]byte
-		copy(screenshot[:], data)
+func (db *gameDB) findGameByCRC(crc string) (*gameMatch, error) {
+	return db.findGame("SELECT g.id, g.name, g.year, g.genre, s.data, c.disc FROM checksum AS c JOIN game AS g ON c.game_id = g.id LEFT JOIN screenshot AS s ON g.screenshot_id = s.id WHERE c.crc = ?", crc)
+}
 
-		// XXX Should only enable this if there is a genre and/or year?
-		screenshot[0x700] = 1
-		if genre.Valid {
-			screenshot[0x701] = byte(genre.Int64)
+// findGameBySerial finds the game with the given serial for the system,
+// preferring one with the same region. A serial learnt before its system
+// was recorded matches any system
+func (db *gameDB) findGameBySerial(serial, region string, system rom.System) (*gameMatch, error) {
+	return db.findGame("SELECT g.id, g.name, g.year, g.genre, s.data, NULL FROM serial AS p JOIN game AS g ON p.game_id = g.id LEFT JOIN screenshot AS s ON g.screenshot_id = s.id WHERE p.serial = ? AND p.system IN (?, '') ORDER BY p.system = ? DESC, p.region = ? DESC, s.data IS NULL LIMIT 1", serial, system.String(), system.String(), region)
+}
+
+func (db *gameDB) FindScreenshotByCRC(crc string) ([]byte, error) {
+	g, err := db.findGameByCRC(crc)
+	if err != nil || g == nil {
+		return nil, err
+	}
+	return g.screenshot, nil
+}
+
+func (db *gameDB) addSerial(game int64, serial, region string, system rom.System) error {
+	if _, err := db.db.Exec("INSERT OR IGNORE INTO serial (game_id, serial, region, system) VALUES (?, ?, ?, ?)", game, serial, region, system.String()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func nullInt64(v int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(v), Valid: v != 0}
+}
+
+// harvestGame adds a game found in an existing metadata database. If the
+// checksum or name is already known then only the missing checksum,
+// screenshot, genre or year of the existing game is filled in. It reports
+// whether anything changed
+func (db *gameDB) harvestGame(name, crc string, screenshot []byte) (bool, error) {
+	g, y, _ := metadata.Info(screenshot)
+	genre, year := nullInt64(g), nullInt64(y)
+
+	var id int64
+	var screenshotID, oldGenre, oldYear sql.NullInt64
+	switch err := db.db.QueryRow("SELECT g.id, g.screenshot_id, g.genre, g.year FROM checksum AS c JOIN game AS g ON c.game_id = g.id WHERE c.crc = ?", crc).Scan(&id, &screenshotID, &oldGenre, &oldYear); err {
+	case sql.ErrNoRows:
+		switch err := db.db.QueryRow("SELECT id, screenshot_id, genre, year FROM game WHERE name = ?", name).Scan(&id, &screenshotID, &oldGenre, &oldYear); err {
+		case sql.ErrNoRows:
+			s, err := db.addScreenshotData(screenshot)
+			if err != nil {
+				return false, err
+			}
+
+			if id, err = db.addGame(name, year, genre, sql.NullInt64{Int64: s, Valid: true}); err != nil {
+				return false, err
+			}
+
+			return true, db.addChecksum(id, crc)
+		case nil:
+			if err := db.addChecksum(id, crc); err != nil {
+				return false, err
+			}
+		default:
+			return false, err
 		}
-		if year.Valid {
-			screenshot[0x702] = byte(year.Int64 & 0xff)
-			screenshot[0x703] = byte(year.Int64 >> 8 & 0xff)
+	case nil:
+		if screenshotID.Valid && (oldGenre.Valid || !genre.Valid) && (oldYear.Valid || !year.Valid) {
+			return false, nil
 		}
-
-		return screenshot[:], nil
 	default:
-		return nil, err
+		return false, err
 	}
+
+	if !screenshotID.Valid {
+		s, err := db.addScreenshotData(screenshot)
+		if err != nil {
+			return false, err
+		}
+		screenshotID = sql.NullInt64{Int64: s, Valid: true}
+	}
+
+	if _, err := db.db.Exec("UPDATE game SET screenshot_id = ?, genre = IFNULL(genre, ?), year = IFNULL(year, ?) WHERE id = ?", screenshotID, genre, year, id); err != nil {
+		return false, err
+	}
+
+	return true, nil
 }
 
 // Close closes the database
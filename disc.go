@@ -0,0 +1,115 @@
+package megasd
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var discPattern = regexp.MustCompile(`(?i)\b(?:disc|disk|cd)\s*#?\s*([0-9]+)\b`)
+
+// discNumber returns the disc number in the name, such as "(Disc 2)", or
+// zero if there isn't one
+func discNumber(name string) int {
+	m := discPattern.FindStringSubmatch(name)
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+// DiscLayout describes a CD directory that won't display correctly on the
+// MegaSD, such as a multi-disc game whose discs can't be told apart
+type DiscLayout struct {
+	Dir     string
+	Problem string
+}
+
+// checkDiscs identifies each disc in a CD directory, records which disc of
+// a multi-disc game each one is and reports any problems with the layout.
+// Each cue sheet of a multi-disc game should be named with its disc number
+// so that they sort in order
+func (m *MegaSD) checkDiscs(d *cueDir, r *Report) error {
+	if len(d.cues) == 0 {
+		return nil
+	}
+
+	problem := func(format string, a ...interface{}) {
+		p := DiscLayout{Dir: d.dir, Problem: fmt.Sprintf(format, a...)}
+		m.logger.Printf("Problem with \"%s\": %s\n", p.Dir, p.Problem)
+		r.addDiscLayout(p)
+	}
+
+	games := make(map[int64]string)
+	crcs := make(map[string]string)
+	discs := make(map[int]string)
+	numbers := make([]int, 0, len(d.cues))
+
+	for _, cue := range d.cues {
+		// The scan itself reports any disc that can't be read
+		crc, err := crcDiscFile(filepath.Join(d.dir, cue))
+		if err != nil || crc == "" {
+			problem("\"%s\" has no readable data track", cue)
+			numbers = append(numbers, 0)
+			continue
+		}
+
+		n := discNumber(strings.TrimSuffix(cue, filepath.Ext(cue)))
+		if n == 0 && len(d.cues) == 1 {
+			n = discNumber(filepath.Base(d.dir))
+		}
+
+		g, err := m.db.findGameByCRC(crc)
+		if err != nil {
+			return err
+		}
+		if g != nil {
+			switch {
+			case g.disc != 0 && n != 0 && g.disc != n:
+				problem("\"%s\" is named as disc %d but is disc %d of \"%s\"", cue, n, g.disc, g.name)
+				n = g.disc
+			case g.disc != 0:
+				n = g.disc
+			case n != 0:
+				if err := m.db.setDisc(crc, n); err != nil {
+					return err
+				}
+			}
+			games[g.id] = g.name
+		}
+
+		if other, ok := crcs[crc]; ok {
+			problem("\"%s\" and \"%s\" are the same disc", other, cue)
+		}
+		crcs[crc] = cue
+
+		if other, ok := discs[n]; ok && n != 0 {
+			problem("\"%s\" and \"%s\" are both disc %d", other, cue, n)
+		}
+		discs[n] = cue
+		numbers = append(numbers, n)
+	}
+
+	if len(d.cues) < 2 {
+		return nil
+	}
+
+	if len(games) > 1 {
+		problem("%d different games share the directory so it only shows the screenshot of the first, move each game into its own directory", len(games))
+	}
+
+	for i, n := range numbers {
+		if n == 0 {
+			problem("the disc number of \"%s\" is unknown, include \"(Disc N)\" in its name", d.cues[i])
+		}
+	}
+
+	if numbers[0] > 1 {
+		problem("\"%s\" sorts first but is disc %d, rename the cue sheets so they sort in disc order", d.cues[0], numbers[0])
+	}
+
+	return nil
+}
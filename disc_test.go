@@ -0,0 +1,20 @@
+package megasd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscNumber(t *testing.T) {
+	tables := map[string]int{
+		"Snatcher (USA) (Disc 1)": 1,
+		"Night Trap (Disk 2)":     2,
+		"Dungeon Explorer CD2":    2,
+		"Sonic CD":                0,
+		"Lunar - The Silver Star": 0,
+	}
+	for name, n := range tables {
+		assert.Equal(t, n, discNumber(name), name)
+	}
+}
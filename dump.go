@@ -0,0 +1,76 @@
+package megasd
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/bodgit/megasd/metadata"
+)
+
+// Entry describes a single screenshot stored in a metadata database
+type Entry struct {
+	CRC        uint32
+	Name       string // Matching file or directory, empty if there isn't one
+	Genre      string
+	Year       int
+	Screenshot []byte
+}
+
+// Dump is the result of matching the metadata database in a directory
+// against the files in that directory
+type Dump struct {
+	Entries []Entry
+	Missing []string // Files or directories without an entry
+}
+
+// DumpDirectory reads the metadata database in dir and matches each entry to
+// the file or directory whose name hashes to the same CRC
+func DumpDirectory(dir string) (*Dump, error) {
+	db, err := readMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	games, err := candidates(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[uint32]string)
+	for _, g := range games {
+		crc := metadata.CRCFilename(g.name)
+		if _, ok := names[crc]; ok {
+			continue
+		}
+
+		// Show the file including its extension, or the CD directory
+		name := filepath.Base(g.path)
+		if g.cd() {
+			name = g.name
+		}
+		names[crc] = name
+	}
+
+	dump := new(Dump)
+
+	for _, crc := range db.CRCs() {
+		screenshot, _ := db.Get(crc)
+		e := Entry{
+			CRC:        crc,
+			Name:       names[crc],
+			Screenshot: screenshot,
+		}
+		if g, y, ok := metadata.Info(screenshot); ok {
+			e.Genre, e.Year = genre(g).String(), y
+		}
+		dump.Entries = append(dump.Entries, e)
+		delete(names, crc)
+	}
+
+	for _, name := range names {
+		dump.Missing = append(dump.Missing, name)
+	}
+	sort.Strings(dump.Missing)
+
+	return dump, nil
+}
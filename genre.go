@@ -1,5 +1,11 @@
 package megasd
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 type genre int
 
 const (
@@ -25,3 +31,48 @@ const (
 	genreHorseRacing
 	genreOther
 )
+
+var genreNames = map[genre]string{
+	genreShooter:     "Shooter",
+	genreAction:      "Action",
+	genreSports:      "Sports",
+	genreMisc:        "Misc",
+	genreCasino:      "Casino",
+	genreDriving:     "Driving",
+	genrePlatform:    "Platform",
+	genrePuzzle:      "Puzzle",
+	genreBoxing:      "Boxing",
+	genreWrestling:   "Wrestling",
+	genreStrategy:    "Strategy",
+	genreSoccer:      "Soccer",
+	genreGolf:        "Golf",
+	genreBeatEmUp:    "Beat 'em up",
+	genreBaseball:    "Baseball",
+	genreMahjong:     "Mahjong",
+	genreBoard:       "Board",
+	genreTennis:      "Tennis",
+	genreFighter:     "Fighter",
+	genreHorseRacing: "Horse racing",
+	genreOther:       "Other",
+}
+
+func (g genre) String() string {
+	if s, ok := genreNames[g]; ok {
+		return s
+	}
+	return ""
+}
+
+func parseGenre(s string) (genre, error) {
+	if i, err := strconv.Atoi(s); err == nil {
+		if _, ok := genreNames[genre(i)]; ok {
+			return genre(i), nil
+		}
+	}
+	for g, name := range genreNames {
+		if strings.EqualFold(s, name) {
+			return g, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown genre \"%s\"", s)
+}
@@ -8,5 +8,5 @@ require (
 	github.com/mattn/go-sqlite3 v1.13.0
 	github.com/stretchr/testify v1.4.0
 	github.com/urfave/cli/v2 v2.0.0
-	github.com/vchimishuk/chub v0.0.0-20190501162134-36f1f5f7c9ef
+	golang.org/x/text v0.3.2
 )
@@ -0,0 +1,112 @@
+package megasd
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/bodgit/megasd/metadata"
+	"github.com/bodgit/megasd/rom"
+)
+
+// harvestCandidate imports the screenshot found for a single game. A game
+// that can't be checksummed is logged and skipped, only database errors are
+// returned
+func (m *MegaSD) harvestCandidate(g candidate, screenshot []byte) error {
+	crc, err := g.crc()
+	if err != nil {
+		m.logger.Printf("Unable to checksum \"%s\": %s\n", g.path, err)
+		return nil
+	}
+	if crc == "" {
+		m.logger.Printf("Unable to checksum \"%s\"\n", g.path)
+		return nil
+	}
+
+	changed, err := m.db.harvestGame(g.name, crc, screenshot)
+	if err != nil {
+		return err
+	}
+
+	if h, err := g.system.Header(g.path); err == nil {
+		game, err := m.db.findGameByCRC(crc)
+		if err != nil {
+			return err
+		}
+		if game != nil {
+			if err := m.learnSerial(game.id, h); err != nil {
+				return err
+			}
+		}
+	}
+	if changed {
+		m.logger.Printf("Harvested \"%s\", with CRC \"%s\"\n", g.path, crc)
+	}
+
+	return nil
+}
+
+// harvestDirectory imports the screenshot of every game in the metadata of
+// dir. Like a scan, a game or metadata database that can't be read is only
+// logged so it doesn't stop the rest being harvested
+func (m *MegaSD) harvestDirectory(dir string) error {
+	db, err := readMetadata(dir)
+	if err != nil {
+		m.logger.Printf("Unable to read the metadata in \"%s\": %s\n", dir, err)
+		return nil
+	}
+	if db.Length() == 0 {
+		return nil
+	}
+
+	games, err := candidates(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, g := range games {
+		screenshot, ok := db.Get(metadata.CRCFilename(g.name))
+		if !ok {
+			continue
+		}
+
+		if err := m.harvestCandidate(g, screenshot); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// learnSerial records the serial from the header against the game so that
+// it can be used to match other revisions
+func (m *MegaSD) learnSerial(game int64, h *rom.Header) error {
+	if h == nil || h.ProductCode() == "" {
+		return nil
+	}
+	return m.db.addSerial(game, h.ProductCode(), h.Regions, h.System)
+}
+
+// Harvest traverses the given directory and imports the screenshot, genre
+// and year of any game found in an existing metadata database into the
+// internal database
+func (m *MegaSD) Harvest(path string) error {
+	return filepath.Walk(path, func(dir string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Ignore any hidden files or directories
+		if info.Name()[0] == '.' {
+			if info.Mode().IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.Mode().IsDir() {
+			return nil
+		}
+
+		return m.harvestDirectory(dir)
+	})
+}
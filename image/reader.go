@@ -32,6 +32,9 @@ func lowerNibble(b byte) byte {
 type decoder struct {
 	r io.Reader
 
+	// Ignore any data following the image
+	lenient bool
+
 	numPalettes int
 
 	image   *image.Paletted
@@ -93,11 +96,13 @@ func (d *decoder) decode(r io.Reader, configOnly bool) error {
 		return errNotEnough
 	}
 
-	if n, err := r.Read(d.tmp[:1]); n != 0 || (err != io.EOF && err != io.ErrUnexpectedEOF) {
-		if err != nil {
-			return err
+	if !d.lenient {
+		if n, err := r.Read(d.tmp[:1]); n != 0 || (err != io.EOF && err != io.ErrUnexpectedEOF) {
+			if err != nil {
+				return err
+			}
+			return errTooMuch
 		}
-		return errTooMuch
 	}
 
 	if configOnly {
@@ -137,6 +142,17 @@ func Decode(r io.Reader) (image.Image, error) {
 	return d.image, nil
 }
 
+// DecodeLenient reads a MegaSD image from r like Decode but ignores any data
+// following the image, such as the padding of a screenshot stored in the
+// metadata database.
+func DecodeLenient(r io.Reader) (image.Image, error) {
+	d := decoder{lenient: true}
+	if err := d.decode(r, false); err != nil {
+		return nil, err
+	}
+	return d.image, nil
+}
+
 // DecodeConfig returns the color model and dimensions of a MegaSD image without
 // decoding the entire tile.
 func DecodeConfig(r io.Reader) (image.Config, error) {
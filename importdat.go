@@ -0,0 +1,195 @@
+package megasd
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bodgit/megasd/dat"
+	"github.com/bodgit/megasd/rom"
+)
+
+// datSystem works out the system from the name of a No-Intro or Redump DAT
+// file, such as "Sega - Mega Drive - Genesis"
+func datSystem(name string) (rom.System, error) {
+	switch {
+	case strings.Contains(name, "Mega-CD"), strings.Contains(name, "Mega CD"), strings.Contains(name, "Sega CD"):
+		return rom.SystemMegaCD, nil
+	case strings.Contains(name, "32X"):
+		return rom.System32X, nil
+	case strings.Contains(name, "Master System"), strings.Contains(name, "Mark III"):
+		return rom.SystemMasterSystem, nil
+	case strings.Contains(name, "SG-1000"):
+		return rom.SystemSG1000, nil
+	case strings.Contains(name, "Mega Drive"), strings.Contains(name, "Genesis"):
+		return rom.SystemMegaDrive, nil
+	}
+	return rom.SystemUnknown, fmt.Errorf("unsupported DAT \"%s\"", name)
+}
+
+// ImportDAT imports the entries from a DAT file, replacing any previously
+// imported for the same system, and links them to the games in the
+// database. It returns the number of entries and how many were linked
+func (db *gameDB) ImportDAT(file string) (int, int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	d, err := dat.Parse(f)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	system, err := datSystem(d.Name)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE track_hash SET dat_id = NULL WHERE dat_id IN (SELECT id FROM dat WHERE system = ?)", system.String()); err != nil {
+		return 0, 0, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM dat WHERE system = ?", system.String()); err != nil {
+		return 0, 0, err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO dat (system, name, region, revision, file, size, crc, md5, sha1) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer stmt.Close()
+
+	var total int
+	for _, g := range d.Games {
+		name := dat.ParseName(g.Name)
+		for _, r := range g.ROMs {
+			if _, err := stmt.Exec(system.String(), g.Name, strings.Join(name.Regions, ", "), name.Revision, r.Name, r.Size, r.CRC, r.MD5, r.SHA1); err != nil {
+				return 0, 0, err
+			}
+			total++
+		}
+	}
+
+	// Any hashed tracks need to point at the new entries
+	if _, err := tx.Exec("UPDATE track_hash SET dat_id = (SELECT id FROM dat WHERE sha1 = track_hash.sha1 AND system = ? ORDER BY name LIMIT 1)", rom.SystemMegaCD.String()); err != nil {
+		return 0, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+
+	linked, err := db.linkDAT(system)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return total, linked, nil
+}
+
+// linkDAT links any unlinked DAT entries for the given system to a game,
+// either by CRC or by name, and returns how many were linked
+func (db *gameDB) linkDAT(system rom.System) (int, error) {
+	var linked int64
+
+	// The CRC from crcFile only matches the CRC of the whole file if there
+	// is no copier header, which a DAT entry shouldn't have anyway. The
+	// checksum of a CD covers just the first sector so can't be used
+	if system != rom.SystemMegaCD {
+		result, err := db.db.Exec("UPDATE dat SET game_id = (SELECT game_id FROM checksum WHERE crc = dat.crc) WHERE system = ? AND game_id IS NULL AND size & 4095 = 0 AND crc IN (SELECT crc FROM checksum)", system.String())
+		if err != nil {
+			return 0, err
+		}
+		if linked, err = result.RowsAffected(); err != nil {
+			return 0, err
+		}
+	}
+
+	games, err := db.gamesByName(system)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := db.db.Query("SELECT id, name FROM dat WHERE system = ? AND game_id IS NULL", system.String())
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	links := make(map[int64]int64)
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return 0, err
+		}
+		if game, ok := games[normalizeName(name)]; ok && game.Valid {
+			links[id] = game.Int64
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	for id, game := range links {
+		if _, err := db.db.Exec("UPDATE dat SET game_id = ? WHERE id = ?", game, id); err != nil {
+			return 0, err
+		}
+	}
+
+	return int(linked) + len(links), nil
+}
+
+// gamesByName returns every game not linked to a DAT entry of a different
+// system keyed by its normalized name, the same games findGameByName
+// considers. If more than one game has the same normalized name then the ID
+// is marked as invalid
+func (db *gameDB) gamesByName(system rom.System) (map[string]sql.NullInt64, error) {
+	rows, err := db.db.Query("SELECT id, name FROM game AS g WHERE NOT EXISTS (SELECT 1 FROM dat WHERE game_id = g.id) OR EXISTS (SELECT 1 FROM dat WHERE game_id = g.id AND system = ?)", system.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	games := make(map[string]sql.NullInt64)
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		key := normalizeName(name)
+		if _, ok := games[key]; ok {
+			games[key] = sql.NullInt64{}
+			continue
+		}
+		games[key] = sql.NullInt64{Int64: id, Valid: true}
+	}
+
+	return games, rows.Err()
+}
+
+// ImportDAT parses the provided No-Intro or Redump DAT file and imports it
+// into the internal database, replacing any DAT previously imported for the
+// same system
+func (m *MegaSD) ImportDAT(file string) error {
+	total, linked, err := m.db.ImportDAT(file)
+	if err != nil {
+		return err
+	}
+
+	m.logger.Printf("Imported %d entries from \"%s\", %d linked to games\n", total, filepath.Base(file), linked)
+
+	return nil
+}
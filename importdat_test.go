@@ -0,0 +1,65 @@
+package megasd
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/bodgit/megasd/rom"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinkDATByName(t *testing.T) {
+	m, cleanup := newTestMegaSD(t)
+	defer cleanup()
+
+	// Already linked to a Master System DAT entry by its CRC
+	addNamedGame(t, m, "Shinobi (Japan)", rom.SystemMasterSystem)
+	unlinked := addNamedGame(t, m, "Columns (World)", rom.SystemUnknown)
+
+	for _, name := range []string{"Shinobi (World)", "Columns (Japan)"} {
+		if _, err := m.db.db.Exec("INSERT INTO dat (system, name, region, revision, file, size, crc, md5, sha1) VALUES (?, ?, '', '', '', 0, '', '', '')", rom.SystemMegaDrive.String(), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	linked, err := m.db.linkDAT(rom.SystemMegaDrive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, linked)
+
+	var game sql.NullInt64
+	if err := m.db.db.QueryRow("SELECT game_id FROM dat WHERE name = 'Shinobi (World)'").Scan(&game); err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, game.Valid)
+	if err := m.db.db.QueryRow("SELECT game_id FROM dat WHERE name = 'Columns (Japan)'").Scan(&game); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, unlinked, game.Int64)
+}
+
+func TestSerialSystem(t *testing.T) {
+	m, cleanup := newTestMegaSD(t)
+	defer cleanup()
+
+	md := addNamedGame(t, m, "Mega Drive Game", rom.SystemUnknown)
+	sms := addNamedGame(t, m, "Master System Game", rom.SystemUnknown)
+
+	if err := m.db.addSerial(md, "1234", "U", rom.SystemMegaDrive); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.db.addSerial(sms, "1234", "U", rom.SystemMasterSystem); err != nil {
+		t.Fatal(err)
+	}
+
+	for system, id := range map[rom.System]int64{rom.SystemMegaDrive: md, rom.SystemMasterSystem: sms} {
+		g, err := m.db.findGameBySerial("1234", "U", system)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if assert.NotNil(t, g) {
+			assert.Equal(t, id, g.id, system.String())
+		}
+	}
+}
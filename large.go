@@ -0,0 +1,299 @@
+package megasd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bodgit/megasd/metadata"
+)
+
+// LargeDirectory describes a directory with more games than its metadata
+// database can hold
+type LargeDirectory struct {
+	Dir string
+	// Games is the number of candidate games when the directory is split,
+	// otherwise the number of games matched
+	Games int
+	// Split lists the sub-directories the games were moved into, if any
+	Split []string
+	// Omitted lists the games left out of the metadata database
+	Omitted []string
+}
+
+// initial returns the upper-cased first letter of the name, or '#' for
+// anything else such as a digit
+func initial(name string) byte {
+	if name == "" {
+		return '#'
+	}
+	c := name[0]
+	switch {
+	case c >= 'a' && c <= 'z':
+		return c - 'a' + 'A'
+	case c >= 'A' && c <= 'Z':
+		return c
+	}
+	return '#'
+}
+
+// bucket is a group of games whose names start with a range of letters
+type bucket struct {
+	first, last byte
+	games       []candidate
+}
+
+func (b bucket) name() string {
+	if b.first == b.last {
+		return string(b.first)
+	}
+	return fmt.Sprintf("%c-%c", b.first, b.last)
+}
+
+// splitBuckets groups the games by the first letter of their name into as
+// few alphabetical ranges as needed to fit in a metadata database each,
+// keeping them roughly the same size. A single letter with too many games
+// still ends up in a range of its own
+func splitBuckets(games []candidate) []bucket {
+	letters := make(map[byte][]candidate)
+	for _, g := range games {
+		c := initial(g.displayName())
+		letters[c] = append(letters[c], g)
+	}
+
+	keys := make([]byte, 0, len(letters))
+	for c := range letters {
+		keys = append(keys, c)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	n := (len(games) + metadata.MaxEntries - 1) / metadata.MaxEntries
+	target := (len(games) + n - 1) / n
+
+	var buckets []bucket
+	for _, c := range keys {
+		if len(buckets) > 0 {
+			b := &buckets[len(buckets)-1]
+			if len(b.games) < target && len(b.games)+len(letters[c]) <= metadata.MaxEntries {
+				b.last = c
+				b.games = append(b.games, letters[c]...)
+				continue
+			}
+		}
+		buckets = append(buckets, bucket{first: c, last: c, games: letters[c]})
+	}
+
+	return buckets
+}
+
+// splitDirectory moves the games in dir into alphabetical sub-directories
+// and removes the metadata database that described them. A loose game is
+// moved along with its tracks and any other file with the same name. If a
+// move fails every file already moved is put back, otherwise each move is
+// written to the undo log, if any
+func (m *MegaSD) splitDirectory(dir string, games []candidate, o *scanOptions) (dirs []string, err error) {
+	var moves []Rename
+	var created []string
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(moves) - 1; i >= 0; i-- {
+			if rerr := os.Rename(moves[i].To, moves[i].From); rerr != nil {
+				m.logger.Printf("Unable to move \"%s\" back to \"%s\": %s\n", moves[i].To, moves[i].From, rerr)
+			}
+		}
+		// Only removes the directories if they're empty
+		for i := len(created) - 1; i >= 0; i-- {
+			os.Remove(created[i])
+		}
+	}()
+
+	d, err := readCueDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	moved := make(map[string]struct{})
+
+	for _, b := range splitBuckets(games) {
+		sub := filepath.Join(dir, b.name())
+		if _, err := os.Lstat(sub); os.IsNotExist(err) {
+			if err := os.Mkdir(sub, 0755); err != nil {
+				return nil, err
+			}
+			created = append(created, sub)
+		}
+		dirs = append(dirs, sub)
+
+		for _, g := range b.games {
+			// A loose game takes its tracks and any other file with the
+			// same name with it
+			files := []string{g.file()}
+			if !g.cd() {
+				files = append(files, d.companions(g.file())...)
+			}
+
+			for _, from := range files {
+				if _, ok := moved[from]; ok {
+					continue
+				}
+				to := filepath.Join(sub, filepath.Base(from))
+				if _, err := os.Lstat(to); err == nil {
+					return nil, &os.LinkError{Op: "split", Old: from, New: to, Err: os.ErrExist}
+				}
+				if err := os.Rename(from, to); err != nil {
+					return nil, err
+				}
+				moved[from] = struct{}{}
+				moves = append(moves, Rename{From: from, To: to, Game: g.displayName()})
+			}
+		}
+		m.logger.Printf("Moved %d games into \"%s\"\n", len(b.games), sub)
+	}
+
+	// Only moves that weren't put back are logged
+	if o.log != nil {
+		for _, r := range moves {
+			if err := json.NewEncoder(o.log).Encode(r); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Every game has moved so the old metadata no longer applies
+	if err := os.Remove(filepath.Join(dir, metadata.Filename)); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return dirs, nil
+}
+
+// checkLargeDirectories finds any directory with more games than its
+// metadata database can hold before the scan starts. They are either split
+// into alphabetical sub-directories or, later on, only a subset of games
+// is written to the metadata. Nothing has been identified yet so every
+// candidate is counted, including any game that won't match
+func (m *MegaSD) checkLargeDirectories(base string, o *scanOptions, r *Report) error {
+	var dirs []string
+	if err := filepath.Walk(base, func(dir string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Ignore any hidden files or directories
+		if info.Name()[0] == '.' {
+			if info.Mode().IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.Mode().IsDir() {
+			dirs = append(dirs, dir)
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// Work from the deepest directory upwards so moving a CD directory
+	// doesn't invalidate any path still to be checked
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir := dirs[i]
+		games, err := candidates(dir)
+		if err != nil {
+			return err
+		}
+		if len(games) <= metadata.MaxEntries {
+			continue
+		}
+
+		m.logger.Printf("\"%s\" has %d games, more than the %d its metadata can hold\n", dir, len(games), metadata.MaxEntries)
+		if !o.splitDirectories {
+			continue
+		}
+
+		split, err := m.splitDirectory(dir, games, o)
+		if err != nil {
+			return err
+		}
+		r.addLargeDirectory(LargeDirectory{
+			Dir:   dir,
+			Games: len(games),
+			Split: split,
+		})
+	}
+
+	return nil
+}
+
+// trimMetadata removes games from the metadata database of dir until it
+// fits. Games matched by their CRC are kept before those only matched by
+// their serial or name, otherwise games are kept in the alphabetical order the
+// firmware lists them in
+func (m *MegaSD) trimMetadata(dir string, db *metadata.DB, r *Report) error {
+	if db.Length() <= metadata.MaxEntries {
+		return nil
+	}
+
+	games, err := candidates(dir)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[uint32]string)
+	for _, g := range games {
+		crc := metadata.CRCFilename(g.name)
+		if _, ok := names[crc]; !ok {
+			names[crc] = g.displayName()
+		}
+	}
+
+	fallback := make(map[uint32]struct{})
+	for _, file := range r.fallbackMatchFiles() {
+		var name string
+		switch {
+		case filepath.Dir(file) == dir:
+			name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		case filepath.Dir(filepath.Dir(file)) == dir:
+			name = filepath.Base(filepath.Dir(file))
+		default:
+			continue
+		}
+		fallback[metadata.CRCFilename(name)] = struct{}{}
+	}
+
+	crcs := db.CRCs()
+	for _, crc := range crcs {
+		if _, ok := names[crc]; !ok {
+			names[crc] = fmt.Sprintf("%08X", crc)
+		}
+	}
+	sort.SliceStable(crcs, func(i, j int) bool {
+		_, si := fallback[crcs[i]]
+		_, sj := fallback[crcs[j]]
+		if si != sj {
+			return sj
+		}
+		return strings.ToUpper(names[crcs[i]]) < strings.ToUpper(names[crcs[j]])
+	})
+
+	l := LargeDirectory{
+		Dir:   dir,
+		Games: db.Length(),
+	}
+	for _, crc := range crcs[metadata.MaxEntries:] {
+		db.Delete(crc)
+		l.Omitted = append(l.Omitted, names[crc])
+	}
+	sort.Strings(l.Omitted)
+
+	m.logger.Printf("Left %d games out of the metadata in \"%s\"\n", len(l.Omitted), dir)
+	r.addLargeDirectory(l)
+
+	return nil
+}
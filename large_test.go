@@ -0,0 +1,115 @@
+package megasd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bodgit/megasd/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+// testGames returns n games whose names start with prefix
+func testGames(prefix string, n int) []candidate {
+	s := lookupSystem("game.md")
+	games := make([]candidate, 0, n)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("%s%04d", prefix, i)
+		games = append(games, candidate{name, filepath.Join("games", name+".md"), s, false})
+	}
+	return games
+}
+
+func TestSplitBuckets(t *testing.T) {
+	tables := []struct {
+		games   []candidate
+		buckets []string
+	}{
+		{
+			append(testGames("A", 600), testGames("B", 600)...),
+			[]string{"A", "B"},
+		},
+		{
+			append(append(testGames("a", 400), testGames("B", 400)...), testGames("C", 400)...),
+			[]string{"A-B", "C"},
+		},
+		{
+			append(testGames("S", 1100), testGames("T", 10)...),
+			[]string{"S", "T"},
+		},
+		{
+			append(append(testGames("1", 300), testGames("[", 300)...), testGames("Z", 1000)...),
+			[]string{"#", "Z"},
+		},
+	}
+
+	for _, table := range tables {
+		buckets := splitBuckets(table.games)
+
+		var names []string
+		total := 0
+		for _, b := range buckets {
+			names = append(names, b.name())
+			total += len(b.games)
+			for _, g := range b.games {
+				c := initial(g.displayName())
+				assert.True(t, c >= b.first && c <= b.last, g.name)
+			}
+		}
+		assert.Equal(t, table.buckets, names)
+		assert.Equal(t, len(table.games), total)
+
+		// Only a single letter with too many games can overflow
+		for _, b := range buckets {
+			if b.first != b.last {
+				assert.True(t, len(b.games) <= metadata.MaxEntries, b.name())
+			}
+		}
+	}
+}
+
+func TestSplitDirectory(t *testing.T) {
+	m, cleanup := newTestMegaSD(t)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "megasd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"Alpha (Disc 1).cue":            "FILE \"Alpha (Disc 1).bin\" BINARY\r\n  TRACK 01 MODE1/2352\r\n    INDEX 01 00:00:00\r\n",
+		"Alpha (Disc 1).bin":            "",
+		"Alpha (Disc 2).cue":            "FILE \"Alpha (Disc 2) (Track 01).bin\" BINARY\r\n  TRACK 01 MODE1/2352\r\n    INDEX 01 00:00:00\r\n",
+		"Alpha (Disc 2) (Track 01).bin": "",
+		"Beta.md":                       "",
+		"Beta.srm":                      "",
+	}
+	for file, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	games, err := candidates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, games, 3)
+
+	dirs, err := m.splitDirectory(dir, games, &scanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{filepath.Join(dir, "A-B")}, dirs)
+
+	for file := range files {
+		_, err := os.Stat(filepath.Join(dir, "A-B", file))
+		assert.NoError(t, err, file)
+		_, err = os.Stat(filepath.Join(dir, file))
+		assert.True(t, os.IsNotExist(err), file)
+	}
+}
@@ -0,0 +1,266 @@
+package megasd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bodgit/megasd/cue"
+)
+
+// Severity is how serious a problem found in a cue sheet is
+type Severity int
+
+// Each severity, from least to most serious
+const (
+	// SeverityWarning is a problem that may stop some tracks playing
+	SeverityWarning Severity = iota
+	// SeverityError is a problem that stops the disc working at all
+	SeverityError
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// LintProblem is a single problem found in a cue sheet, with a suggested fix
+type LintProblem struct {
+	File     string
+	Line     int // Line within the cue sheet, zero if not specific to one
+	Severity Severity
+	Message  string
+	Fix      string
+}
+
+// lintModes are the track modes used by Mega CD discs
+var lintModes = map[string]struct{}{
+	cue.ModeAudio:   {},
+	cue.Mode1Cooked: {},
+	cue.Mode1Raw:    {},
+}
+
+// lintFix suggests a fix for each file type the cart can't play
+var lintFix = map[string]string{
+	"MOTOROLA": "convert the disc with megasd convert-disc, which byte-swaps the audio into a BINARY file",
+	"MP3":      "decode the audio to a 44.1 kHz, 16-bit stereo WAVE file",
+	"OGG":      "decode the audio to a 44.1 kHz, 16-bit stereo WAVE file",
+	"FLAC":     "decode the audio to a 44.1 kHz, 16-bit stereo WAVE file",
+	"AIFF":     "decode the audio to a 44.1 kHz, 16-bit stereo WAVE file",
+}
+
+// wavFormat returns the audio format, number of channels, sample rate and
+// bits per sample of a WAV file
+func wavFormat(file string) (int, int, int, int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	defer f.Close()
+
+	var riff [12]byte
+	if _, err := io.ReadFull(f, riff[:]); err != nil {
+		return 0, 0, 0, 0, err
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return 0, 0, 0, 0, errors.New("not a WAV file")
+	}
+
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(f, chunk[:]); err != nil {
+			return 0, 0, 0, 0, err
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		if string(chunk[0:4]) == "fmt " {
+			var b [16]byte
+			if _, err := io.ReadFull(f, b[:]); err != nil {
+				return 0, 0, 0, 0, err
+			}
+			return int(binary.LittleEndian.Uint16(b[0:])), int(binary.LittleEndian.Uint16(b[2:])), int(binary.LittleEndian.Uint32(b[4:])), int(binary.LittleEndian.Uint16(b[14:])), nil
+		}
+		// Chunks are padded to an even size
+		if _, err := f.Seek(size+size&1, io.SeekCurrent); err != nil {
+			return 0, 0, 0, 0, err
+		}
+	}
+}
+
+// resolveFile finds the file referenced by a cue sheet within names, the
+// contents of its directory. The match ignores case if there's no exact match
+func resolveFile(names []string, name string) (string, bool) {
+	var match string
+	for _, n := range names {
+		if n == name {
+			return n, true
+		}
+		if match == "" && strings.EqualFold(n, name) {
+			match = n
+		}
+	}
+	return match, false
+}
+
+// lintFile checks a single FILE entry of a cue sheet
+func lintFile(file string, names []string, f *cue.File) []LintProblem {
+	var problems []LintProblem
+	add := func(line int, s Severity, message, fix string) {
+		problems = append(problems, LintProblem{file, line, s, message, fix})
+	}
+
+	dir := filepath.Dir(file)
+	name := filepath.Base(filepath.FromSlash(strings.Replace(f.Name, "\\", "/", -1)))
+
+	actual, exact := resolveFile(names, name)
+	switch {
+	case actual == "":
+		add(f.Line, SeverityError, fmt.Sprintf("\"%s\" doesn't exist", f.Name), "restore the missing file or correct the FILE entry")
+		return problems
+	case !exact:
+		add(f.Line, SeverityError, fmt.Sprintf("\"%s\" only matches \"%s\" if case is ignored", f.Name, actual), fmt.Sprintf("change the FILE entry to \"%s\"", actual))
+	case name != f.Name:
+		add(f.Line, SeverityWarning, fmt.Sprintf("\"%s\" includes a directory", f.Name), fmt.Sprintf("change the FILE entry to \"%s\"", name))
+	}
+	path := filepath.Join(dir, actual)
+
+	switch f.Type {
+	case "BINARY":
+		info, err := os.Stat(path)
+		if err != nil {
+			add(f.Line, SeverityError, err.Error(), "restore the missing file")
+			break
+		}
+		if len(f.Tracks) == 1 {
+			if size := f.Tracks[0].SectorSize(); size != 0 && info.Size()%int64(size) != 0 {
+				add(f.Line, SeverityWarning, fmt.Sprintf("\"%s\" isn't a whole number of %d byte sectors", f.Name, size), "check the track mode or re-rip the track")
+			}
+		}
+	case "WAVE":
+		format, channels, rate, bits, err := wavFormat(path)
+		switch {
+		case err != nil:
+			add(f.Line, SeverityError, fmt.Sprintf("\"%s\" isn't a readable WAV file", f.Name), "decode the audio to a 44.1 kHz, 16-bit stereo WAVE file")
+		case format != 1 || channels != 2 || rate != 44100 || bits != 16:
+			add(f.Line, SeverityWarning, fmt.Sprintf("\"%s\" is %d Hz, %d-bit, %d channel audio", f.Name, rate, bits, channels), "convert the audio to 44.1 kHz, 16-bit stereo PCM")
+		}
+	default:
+		fix, ok := lintFix[f.Type]
+		if !ok {
+			fix = "convert the file to BINARY, or WAVE for audio"
+		}
+		add(f.Line, SeverityWarning, fmt.Sprintf("%s files can't be played by the cart", f.Type), fix)
+	}
+
+	for _, t := range f.Tracks {
+		if _, ok := lintModes[t.Mode]; !ok {
+			fix := "Mega CD discs only use AUDIO and MODE1 tracks, check this is a Mega CD disc"
+			if t.Mode == "CDG" {
+				fix = "convert the disc with megasd convert-disc, which drops the subchannel data"
+			}
+			add(t.Line, SeverityError, fmt.Sprintf("track %d has unsupported mode \"%s\"", t.Number, t.Mode), fix)
+		}
+		if _, ok := t.Index(1); !ok {
+			add(t.Line, SeverityError, fmt.Sprintf("track %d has no INDEX 01", t.Number), "add an INDEX 01 entry for the start of the track")
+		}
+		if t.IsData() && f.Type != "BINARY" {
+			add(t.Line, SeverityError, fmt.Sprintf("data track %d is stored in a %s file", t.Number, f.Type), "store the data track in a BINARY file")
+		}
+	}
+
+	return problems
+}
+
+// LintCue checks the cue sheet for problems that stop the MegaSD using the
+// disc. A cue sheet that can't be parsed is reported as a single problem
+func LintCue(file string) ([]LintProblem, error) {
+	sheet, err := cue.ParseFile(file)
+	if err != nil {
+		return []LintProblem{{File: file, Severity: SeverityError, Message: err.Error(), Fix: "correct the cue sheet, or convert the disc with megasd convert-disc"}}, nil
+	}
+
+	files, err := ioutil.ReadDir(filepath.Dir(file))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, info := range files {
+		names = append(names, info.Name())
+	}
+
+	var problems []LintProblem
+	for _, f := range sheet.Files {
+		problems = append(problems, lintFile(file, names, f)...)
+	}
+
+	if _, _, err := firstDataTrack(sheet); err != nil {
+		problems = append(problems, LintProblem{File: file, Severity: SeverityError, Message: "there is no MODE1 data track", Fix: "check this is a Mega CD disc and not an audio CD"})
+	}
+
+	return problems, nil
+}
+
+// LintCues checks every cue sheet found under the given path, which may also
+// be a single cue sheet. Each game folder should only have one cue sheet,
+// unless it holds each disc of a multi-disc game
+func LintCues(path string) ([]LintProblem, error) {
+	cues := make(map[string][]string)
+	if err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Ignore any hidden files or directories
+		if info.Name()[0] == '.' && file != path {
+			if info.Mode().IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.Mode().IsRegular() && isCue(file) {
+			cues[filepath.Dir(file)] = append(cues[filepath.Dir(file)], file)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	dirs := make([]string, 0, len(cues))
+	for dir := range cues {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	var problems []LintProblem
+	for _, dir := range dirs {
+		files := cues[dir]
+		sort.Strings(files)
+
+		// Several cue sheets are only expected for the discs of one game
+		for _, file := range files {
+			if len(files) > 1 && discNumber(strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))) == 0 {
+				problems = append(problems, LintProblem{File: dir, Severity: SeverityWarning, Message: fmt.Sprintf("%d cue sheets in the same folder and \"%s\" isn't numbered as a disc, only \"%s\" is used for the folder", len(files), filepath.Base(file), filepath.Base(files[0])), Fix: "move each game into its own folder, or include \"(Disc N)\" in the name of each disc's cue sheet"})
+				break
+			}
+		}
+
+		for _, file := range files {
+			p, err := LintCue(file)
+			if err != nil {
+				return nil, err
+			}
+			problems = append(problems, p...)
+		}
+	}
+
+	return problems, nil
+}
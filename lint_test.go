@@ -0,0 +1,49 @@
+package megasd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintCue(t *testing.T) {
+	tables := []struct {
+		dir      string
+		problems map[Severity]int
+	}{
+		{"good", map[Severity]int{}},
+		{"size", map[Severity]int{SeverityWarning: 1}},
+		{"case", map[Severity]int{SeverityError: 1}},
+		{"missing", map[Severity]int{SeverityError: 1}},
+		{"mode", map[Severity]int{SeverityError: 1}},
+	}
+
+	for _, table := range tables {
+		problems, err := LintCue(filepath.Join("testdata", "lint", table.dir, "game.cue"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		severities := make(map[Severity]int)
+		for _, p := range problems {
+			severities[p.Severity]++
+			assert.NotEmpty(t, p.Fix, p.Message)
+		}
+		assert.Equal(t, table.problems, severities, table.dir)
+	}
+}
+
+func TestLintCues(t *testing.T) {
+	problems, err := LintCues(filepath.Join("testdata", "lint", "cues"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the folder of unnumbered cue sheets is a problem
+	if assert.Len(t, problems, 1) {
+		assert.Equal(t, filepath.Join("testdata", "lint", "cues", "games"), problems[0].File)
+		assert.Equal(t, SeverityWarning, problems[0].Severity)
+		assert.NotEmpty(t, problems[0].Fix)
+	}
+}
@@ -1,18 +1,40 @@
 package metadata
 
 import (
-	"fmt"
-	"strings"
+	"bytes"
 
 	"github.com/bodgit/megasd/crc32"
 )
 
 const filenameTrim = 56
 
+// foldFilename upper-cases and truncates a filename like the firmware is
+// believed to. It's assumed to work on the raw bytes of the name as read
+// from the filesystem so only ASCII letters are folded; the bytes of any
+// multi-byte UTF-8 sequence are left untouched and the name can be cut
+// part-way through a character. This hasn't been confirmed against names
+// hashed on the cartridge itself
+func foldFilename(filename string) [filenameTrim]byte {
+	var b [filenameTrim]byte
+	copy(b[:], filename)
+	for i, c := range b {
+		if c >= 'a' && c <= 'z' {
+			b[i] = c - 'a' + 'A'
+		}
+	}
+	return b
+}
+
 // CRCFilename computes the CRC of a given filename using the same algorithm as
 // implemented in the MegaSD firmware
 func CRCFilename(filename string) uint32 {
-	var b [filenameTrim]byte
-	copy(b[:], []byte(fmt.Sprintf("%.*s", filenameTrim, strings.ToUpper(filename))))
-	return crc32.Update(0xffffffff, b[:])
+	b := foldFilename(filename)
+	return crc32.Update(crc32.Initial, b[:])
+}
+
+// EqualFilename reports whether two filenames are indistinguishable to the
+// MegaSD firmware once they are upper-cased and truncated
+func EqualFilename(a, b string) bool {
+	fa, fb := foldFilename(a), foldFilename(b)
+	return bytes.Equal(fa[:], fb[:])
 }
@@ -0,0 +1,63 @@
+package metadata
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testVectors checks every CRC and filename pair in the file and returns how
+// many there were
+func testVectors(t *testing.T, file string) int {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	n := 0
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if s.Text() == "" || s.Text()[0] == '#' {
+			continue
+		}
+		fields := strings.SplitN(s.Text(), "\t", 2)
+		crc, err := strconv.ParseUint(fields[0], 16, 32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, uint32(crc), CRCFilename(fields[1]), fields[1])
+		n++
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+func TestCRCFilename(t *testing.T) {
+	testVectors(t, "testdata/crcfilename.txt")
+}
+
+func TestCRCFilenameFirmware(t *testing.T) {
+	if testVectors(t, "testdata/firmware.txt") == 0 {
+		t.Skip("no filename CRCs from games.dbs files written by the cartridge")
+	}
+}
+
+func TestCRCFilenameNonASCII(t *testing.T) {
+	// Only ASCII letters are upper-cased. These only check the assumed
+	// behaviour, there are no hashes of non-ASCII names from the cartridge
+	assert.Equal(t, CRCFilename("POKéMON"), CRCFilename("pokémon"))
+	assert.NotEqual(t, CRCFilename("POKÉMON"), CRCFilename("pokémon"))
+
+	// Truncation is by byte, not by character
+	name := strings.Repeat("a", filenameTrim-1)
+	assert.Equal(t, CRCFilename(name+"\xe3"), CRCFilename(name+"ぷ"))
+	assert.True(t, EqualFilename(name+"ぷ", name+"ぴ"))
+}
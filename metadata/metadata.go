@@ -15,13 +15,20 @@ import (
 
 const (
 	// Filename is the expected filename used when writing to disk
-	Filename   = "games.dbs"
-	maxEntries = 1024
+	Filename = "games.dbs"
+	// MaxEntries is the most checksums a database can hold
+	MaxEntries = 1024
 
 	// ScreenshotSize defines the expected size in bytes of each screenshot
 	ScreenshotSize = 2048
+
+	infoOffset = 0x700
 )
 
+// ErrTooManyEntries is returned when marshalling a database with more than
+// MaxEntries checksums
+var ErrTooManyEntries = fmt.Errorf("more than %d entries", MaxEntries)
+
 // DB is the metadata database object. It implements the
 // encoding.BinaryMarshaler and encoding.BinaryUnmarshaler interfaces.
 type DB struct {
@@ -53,19 +60,83 @@ func (db *DB) Set(crc uint32, screenshot []byte) error {
 	return nil
 }
 
-// MarshalBinary encodes the database into binary form and returns the result
-func (db *DB) MarshalBinary() ([]byte, error) {
-	length := len(db.checksums)
+// Delete removes the screenshot for the given CRC and reports whether it was
+// present. The screenshot data itself is only removed once no other CRC
+// refers to it
+func (db *DB) Delete(crc uint32) bool {
+	i, ok := db.checksums[crc]
+	if !ok {
+		return false
+	}
+	delete(db.checksums, crc)
 
-	if length > maxEntries {
-		return nil, fmt.Errorf("more than %d entries", maxEntries)
+	for _, j := range db.checksums {
+		if j == i {
+			return true
+		}
 	}
 
+	db.screenshots = append(db.screenshots[:i], db.screenshots[i+1:]...)
+	for k, j := range db.checksums {
+		if j > i {
+			db.checksums[k] = j - 1
+		}
+	}
+
+	return true
+}
+
+// Get returns the screenshot stored for the given CRC
+func (db *DB) Get(crc uint32) ([]byte, bool) {
+	i, ok := db.checksums[crc]
+	if !ok || int(i) >= len(db.screenshots) {
+		return nil, false
+	}
+	return db.screenshots[i], true
+}
+
+// CRCs returns the checksums in the database in ascending order
+func (db *DB) CRCs() []uint32 {
 	keys := make([]uint32, 0, len(db.checksums))
 	for k := range db.checksums {
 		keys = append(keys, k)
 	}
 	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// Info returns the genre and year stored alongside the image data in a
+// screenshot. The ok value reports whether the information block is enabled
+func Info(screenshot []byte) (genre, year int, ok bool) {
+	if len(screenshot) != ScreenshotSize || screenshot[infoOffset] == 0 {
+		return 0, 0, false
+	}
+	return int(screenshot[infoOffset+1]), int(screenshot[infoOffset+2]) | int(screenshot[infoOffset+3])<<8, true
+}
+
+// SetInfo enables the information block in a screenshot and stores the
+// genre and year. A zero value for either is treated as unknown
+func SetInfo(screenshot []byte, genre, year int) {
+	screenshot[infoOffset] = 1
+	screenshot[infoOffset+1] = byte(genre)
+	screenshot[infoOffset+2] = byte(year & 0xff)
+	screenshot[infoOffset+3] = byte(year >> 8 & 0xff)
+}
+
+// ClearInfo disables and clears the information block in a screenshot
+func ClearInfo(screenshot []byte) {
+	copy(screenshot[infoOffset:infoOffset+4], []byte{0, 0, 0, 0})
+}
+
+// MarshalBinary encodes the database into binary form and returns the result
+func (db *DB) MarshalBinary() ([]byte, error) {
+	length := len(db.checksums)
+
+	if length > MaxEntries {
+		return nil, ErrTooManyEntries
+	}
+
+	keys := db.CRCs()
 
 	b := new(bytes.Buffer)
 
@@ -74,7 +145,7 @@ func (db *DB) MarshalBinary() ([]byte, error) {
 		return nil, err
 	}
 	// Pad to 4096 with 0xff's
-	if _, err := b.Write(bytes.Repeat([]byte{0xff, 0xff, 0xff, 0xff}, maxEntries-length)); err != nil {
+	if _, err := b.Write(bytes.Repeat([]byte{0xff, 0xff, 0xff, 0xff}, MaxEntries-length)); err != nil {
 		return nil, err
 	}
 
@@ -86,7 +157,7 @@ func (db *DB) MarshalBinary() ([]byte, error) {
 		}
 	}
 	// Pad to 6144 with 0xff's
-	if _, err := b.Write(bytes.Repeat([]byte{0xff, 0xff}, maxEntries-length)); err != nil {
+	if _, err := b.Write(bytes.Repeat([]byte{0xff, 0xff}, MaxEntries-length)); err != nil {
 		return nil, err
 	}
 
@@ -108,7 +179,7 @@ func (db *DB) UnmarshalBinary(b []byte) error {
 	db.screenshots = nil
 
 	var keys []uint32
-	for i := 0; i < maxEntries; i++ {
+	for i := 0; i < MaxEntries; i++ {
 		var crc uint32
 		if err := binary.Read(r, binary.LittleEndian, &crc); err != nil {
 			return err
@@ -119,7 +190,7 @@ func (db *DB) UnmarshalBinary(b []byte) error {
 	}
 
 	var maxOffset int
-	for i := 0; i < maxEntries; i++ {
+	for i := 0; i < MaxEntries; i++ {
 		var offset uint16
 		if err := binary.Read(r, binary.LittleEndian, &offset); err != nil {
 			return err
@@ -0,0 +1,218 @@
+package megasd
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/bodgit/megasd/dat"
+	"github.com/bodgit/megasd/rom"
+)
+
+// Apostrophes are removed rather than splitting words
+var apostrophes = strings.NewReplacer("'", "", "\u2019", "")
+
+var articles = map[string]struct{}{
+	"a":   {},
+	"an":  {},
+	"the": {},
+}
+
+// normalizeName reduces a game or file name to the lower-case words of its
+// title, ignoring any region, revision or other tags, punctuation and
+// articles so that differently formatted names of the same game compare
+// equal
+func normalizeName(name string) string {
+	title := dat.ParseName(name).Title
+
+	words := strings.FieldsFunc(apostrophes.Replace(strings.ToLower(title)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	var normalized []string
+	for _, w := range words {
+		if _, ok := articles[w]; !ok {
+			normalized = append(normalized, w)
+		}
+	}
+
+	return strings.Join(normalized, " ")
+}
+
+// romanNumerals are the roman numerals used to number sequels. A lone "X"
+// is more often part of the title, as in "Mega Man X", so isn't included
+var romanNumerals = map[string]int{
+	"i": 1, "ii": 2, "iii": 3, "iv": 4, "v": 5, "vi": 6, "vii": 7, "viii": 8, "ix": 9,
+	"xi": 11, "xii": 12, "xiii": 13, "xiv": 14, "xv": 15, "xvi": 16, "xvii": 17, "xviii": 18, "xix": 19, "xx": 20,
+}
+
+// sequelNumber splits a normalized name into the title and the number of a
+// sequel given by its last word in either arabic or roman numerals. The
+// number is zero if there isn't one
+func sequelNumber(name string) (string, int) {
+	i := strings.LastIndexByte(name, ' ')
+	if i < 0 {
+		return name, 0
+	}
+	last := name[i+1:]
+	if n, err := strconv.Atoi(last); err == nil {
+		return name[:i], n
+	}
+	if n, ok := romanNumerals[last]; ok {
+		return name[:i], n
+	}
+	return name, 0
+}
+
+// similarity scores how alike two normalized names are, from 0 for nothing
+// in common to 1 for identical, using the edit distance between them. Any
+// sequel number has to be the same, in arabic or roman numerals, as a
+// single character would otherwise be a small difference
+func similarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+
+	a, na := sequelNumber(a)
+	b, nb := sequelNumber(b)
+	if na != nb {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+
+	return 1 - float64(prev[len(rb)])/float64(longest)
+}
+
+func minInt(a ...int) int {
+	m := a[0]
+	for _, v := range a[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// namedGame is a game with a screenshot that can be matched by its name
+type namedGame struct {
+	id         int64
+	normalized string
+	systems    map[string]struct{} // Systems of any linked DAT entries or serials
+}
+
+// gameNames loads the name of every game with a screenshot only once, the
+// first time a scan needs to match a game by its name
+type gameNames struct {
+	once  sync.Once
+	games []namedGame
+	err   error
+}
+
+func (n *gameNames) load(db *gameDB) ([]namedGame, error) {
+	n.once.Do(func() {
+		n.games, n.err = db.namedGames()
+	})
+	return n.games, n.err
+}
+
+// namedGames returns every game with a screenshot along with the systems of
+// any DAT entries or serials linked to it
+func (db *gameDB) namedGames() ([]namedGame, error) {
+	rows, err := db.db.Query("SELECT g.id, g.name, x.system FROM game AS g LEFT JOIN (SELECT game_id, system FROM dat UNION SELECT game_id, system FROM serial WHERE system != '') AS x ON x.game_id = g.id WHERE g.screenshot_id IS NOT NULL ORDER BY g.id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var games []namedGame
+	for rows.Next() {
+		var id int64
+		var name string
+		var system sql.NullString
+		if err := rows.Scan(&id, &name, &system); err != nil {
+			return nil, err
+		}
+		if len(games) == 0 || games[len(games)-1].id != id {
+			games = append(games, namedGame{
+				id:         id,
+				normalized: normalizeName(name),
+				systems:    make(map[string]struct{}),
+			})
+		}
+		if system.Valid {
+			games[len(games)-1].systems[system.String] = struct{}{}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return games, nil
+}
+
+// findGameByName finds the game whose normalized name is most similar to
+// that of the given name, scoring at least threshold. Games linked to DAT
+// entries or serials of a different system are never considered, while a
+// game with neither could be for any system. It returns nil if nothing
+// scores highly enough
+func (db *gameDB) findGameByName(games []namedGame, name string, system rom.System, threshold float64) (*gameMatch, float64, error) {
+	normalized := normalizeName(name)
+	if normalized == "" {
+		return nil, 0, nil
+	}
+
+	var best int64
+	var score float64
+	var known bool
+	for _, g := range games {
+		if _, ok := g.systems[system.String()]; !ok && len(g.systems) > 0 {
+			continue
+		}
+		if s := similarity(normalized, g.normalized); s > score {
+			best, score, known = g.id, s, len(g.systems) > 0
+		}
+	}
+
+	if best == 0 || score < threshold {
+		return nil, score, nil
+	}
+
+	g, err := db.findGame("SELECT g.id, g.name, g.year, g.genre, s.data, NULL FROM game AS g LEFT JOIN screenshot AS s ON g.screenshot_id = s.id WHERE g.id = ?", best)
+	if err != nil || g == nil {
+		return nil, 0, err
+	}
+	g.system = known
+
+	return g, score, nil
+}
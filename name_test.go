@@ -0,0 +1,165 @@
+package megasd
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bodgit/megasd/metadata"
+	"github.com/bodgit/megasd/rom"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimilarity(t *testing.T) {
+	assert.Equal(t, 1.0, similarity(normalizeName("The Revenge of Shinobi (USA, Europe) (Rev 1)"), normalizeName("Revenge of Shinobi, The")))
+	assert.Equal(t, 0.8, similarity("sonic", "sonik"))
+	assert.Equal(t, 0.0, similarity("", "sonic"))
+	assert.True(t, similarity("streets of rage 2", "streets of rage") < 0.9)
+	assert.Equal(t, 0.0, similarity("sonic hedgehog 2", "sonic hedgehog 3"))
+	assert.Equal(t, 0.0, similarity("streets of rage ii", "streets of rage iii"))
+	assert.True(t, similarity("sonik hedgehog 2", "sonic hedgehog ii") > 0.9)
+}
+
+func newTestMegaSD(t *testing.T) (*MegaSD, func()) {
+	dir, err := ioutil.TempDir("", "megasd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(filepath.Join(dir, "test.db"), log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return m, func() {
+		m.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// addNamedGame adds a game with a screenshot, optionally linked to a DAT
+// entry of the given system
+func addNamedGame(t *testing.T, m *MegaSD, name string, system rom.System) int64 {
+	screenshot, err := m.db.addScreenshotData(make([]byte, metadata.ScreenshotSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := m.db.addGame(name, sql.NullInt64{}, sql.NullInt64{}, sql.NullInt64{Int64: screenshot, Valid: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if system != rom.SystemUnknown {
+		if _, err := m.db.db.Exec("INSERT INTO dat (system, name, region, revision, file, size, crc, md5, sha1, game_id) VALUES (?, ?, '', '', '', 0, '', '', '', ?)", system.String(), name, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return id
+}
+
+func TestFindGameByName(t *testing.T) {
+	m, cleanup := newTestMegaSD(t)
+	defer cleanup()
+
+	sonic := addNamedGame(t, m, "Sonic the Hedgehog 2", rom.SystemUnknown)
+	shinobi := addNamedGame(t, m, "Shinobi (Japan)", rom.SystemMasterSystem)
+
+	games, err := m.db.namedGames()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tables := []struct {
+		name   string
+		system rom.System
+		id     int64
+	}{
+		{"Sonic the Hedgehog 2 (World)", rom.SystemMegaDrive, sonic},
+		{"Sonik the Hedgehog 2", rom.SystemMegaDrive, sonic},
+		{"Sonic the Hedgehog 3", rom.SystemMegaDrive, 0},
+		{"Shinobi", rom.SystemMasterSystem, shinobi},
+		{"Shinobi", rom.SystemMegaDrive, 0},
+	}
+
+	for _, table := range tables {
+		g, _, err := m.db.findGameByName(games, table.name, table.system, 0.9)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if table.id == 0 {
+			assert.Nil(t, g, table.name)
+			continue
+		}
+		if assert.NotNil(t, g, table.name) {
+			assert.Equal(t, table.id, g.id, table.name)
+		}
+	}
+}
+
+func TestMatchGameLearnNames(t *testing.T) {
+	m, cleanup := newTestMegaSD(t)
+	defer cleanup()
+
+	addNamedGame(t, m, "Sonic the Hedgehog 2", rom.SystemMegaDrive)
+	columns := addNamedGame(t, m, "Columns", rom.SystemUnknown)
+	addNamedGame(t, m, "Flicky", rom.SystemUnknown)
+	if err := m.db.addSerial(columns, "GM 01047000", "JUE", rom.SystemMegaDrive); err != nil {
+		t.Fatal(err)
+	}
+
+	s := lookupSystem("game.md")
+
+	tables := []struct {
+		name    string
+		crc     string
+		learned bool
+	}{
+		{"Sonic the Hedgehog 2 (World)", "11111111", true},
+		{"Sonik the Hedgehog 2", "22222222", false},
+		{"Columns (World)", "33333333", true},
+		// Nothing says which system Flicky is for
+		{"Flicky (USA, Europe)", "44444444", false},
+	}
+
+	for _, table := range tables {
+		o := &scanOptions{
+			matchNames:     true,
+			nameThreshold:  0.9,
+			learnNames:     true,
+			learnThreshold: 1,
+			names:          new(gameNames),
+		}
+		r := new(Report)
+		if err := m.matchGame(table.name+".md", table.name, table.crc, nil, s, metadata.New(), o, r); err != nil {
+			t.Fatal(err)
+		}
+		if assert.Len(t, r.NameMatches, 1, table.name) {
+			assert.Equal(t, table.learned, r.NameMatches[0].Learned, table.name)
+		}
+
+		g, err := m.db.findGameByCRC(table.crc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, table.learned, g != nil, table.name)
+	}
+}
+
+func TestScanNameThreshold(t *testing.T) {
+	m, cleanup := newTestMegaSD(t)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "megasd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, threshold := range []float64{0, -0.5, 1.5} {
+		_, err := m.Scan(dir, MatchNames(threshold))
+		assert.NotNil(t, err)
+	}
+}
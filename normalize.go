@@ -0,0 +1,125 @@
+package megasd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bodgit/megasd/rom"
+)
+
+// Normalization describes a ROM image converted to a plain big-endian image
+type Normalization struct {
+	From  string
+	To    string
+	Fixed rom.Format
+	CRC   string // CRC of the plain image
+	// Name is the name of the matching DAT entry, if any
+	Name string
+	// Header is the parsed header of the plain image, nil if there isn't
+	// one, and Valid reports whether its checksum matches
+	Header *rom.Header
+	Valid  bool
+}
+
+// normalizedName returns the name of the plain image converted from file,
+// using the extension the MegaSD expects
+func normalizedName(file string) string {
+	if hasExt(file, ".gen") || hasExt(file, ".smd") {
+		return strings.TrimSuffix(file, filepath.Ext(file)) + ".md"
+	}
+	return file
+}
+
+// Normalize detects whether the given ROM image has a copier header, is
+// interleaved or is byte-swapped and writes it as a plain big-endian image
+// into dir, or alongside the original if dir is empty. Only a conversion
+// with the same filename replaces the original. The plain image is then
+// verified against the imported DAT files and its own header
+func (m *MegaSD) Normalize(file, dir string) (*Normalization, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Normalization{
+		From: file,
+		To:   normalizedName(file),
+	}
+	if dir != "" {
+		n.To = filepath.Join(dir, filepath.Base(n.To))
+	}
+
+	b, n.Fixed = rom.Normalize(b)
+	n.CRC = crcBytes(b)
+
+	if n.Name, err = m.db.findDAT(n.CRC, len(b)); err != nil {
+		return nil, err
+	}
+	if h, err := rom.Parse(b); err == nil {
+		n.Header, n.Valid = h, !hasChecksum(h) || h.Verify(b)
+	}
+
+	// Nothing to do if it's already a plain image in the right place
+	if n.Fixed == 0 && n.From == n.To {
+		return n, nil
+	}
+
+	// Write to a temporary file first in case the original is replaced
+	f, err := ioutil.TempFile(filepath.Dir(n.To), "."+filepath.Base(n.To))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Chmod(info.Mode().Perm()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	if n.From != n.To {
+		if _, err := os.Lstat(n.To); err == nil {
+			return nil, &os.LinkError{Op: "normalize", Old: n.From, New: n.To, Err: os.ErrExist}
+		}
+	}
+
+	if err := os.Rename(f.Name(), n.To); err != nil {
+		return nil, err
+	}
+	m.logger.Printf("Normalized \"%s\" to \"%s\", with CRC \"%s\"\n", n.From, n.To, n.CRC)
+
+	return n, nil
+}
+
+// NormalizeAll normalizes every ROM image found under the given path, which
+// may also be a single file
+func (m *MegaSD) NormalizeAll(path, dir string) ([]Normalization, error) {
+	files, err := findROMs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Normalization
+	for _, file := range files {
+		n, err := m.Normalize(file, dir)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, *n)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,99 @@
+package megasd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bodgit/megasd/patch"
+)
+
+// Patched describes a ROM image created by applying a patch to a base ROM
+// image
+type Patched struct {
+	File   string
+	Format patch.Format
+	CRC    string // CRC of the patched ROM image, as used by Scan
+	// Game is the name of the base game the new CRC was recorded against,
+	// empty if the base ROM image isn't in the database
+	Game string
+}
+
+// crcROM computes the CRC of a ROM image the same way as crcFile
+func crcROM(b []byte) string {
+	return crcBytes(b[len(b)&0xfff:])
+}
+
+// patchedName returns the name of the patched ROM image, which is the name
+// of the patch with the extension of the base ROM image, alongside the base
+func patchedName(base, p string) string {
+	name := strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
+	return filepath.Join(filepath.Dir(base), name+filepath.Ext(base))
+}
+
+// Patch applies the IPS, BPS or UPS patch to the base ROM image and writes
+// the result to output, or alongside the base ROM image named after the
+// patch if output is empty. The CRC of the patched ROM image is recorded
+// against the base game so it shares the same screenshot, genre and year
+func (m *MegaSD) Patch(base, patchFile, output string) (*Patched, error) {
+	source, err := ioutil.ReadFile(base)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := ioutil.ReadFile(patchFile)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := patch.Apply(source, p)
+	if err != nil {
+		return nil, err
+	}
+
+	if output == "" {
+		output = patchedName(base, patchFile)
+	}
+
+	r := &Patched{
+		File:   output,
+		Format: patch.Detect(p),
+		CRC:    crcROM(target),
+	}
+
+	g, err := m.db.findGameByCRC(crcROM(source))
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(target); err != nil {
+		return nil, err
+	}
+
+	if g == nil {
+		m.logger.Printf("No match for \"%s\", with CRC \"%s\"\n", base, crcROM(source))
+		return r, nil
+	}
+
+	if existing, err := m.db.findGameByCRC(r.CRC); err != nil {
+		return nil, err
+	} else if existing != nil && existing.id != g.id {
+		return r, fmt.Errorf("CRC \"%s\" already belongs to \"%s\"", r.CRC, existing.name)
+	}
+
+	if err := m.db.addChecksum(g.id, r.CRC); err != nil {
+		return nil, err
+	}
+	r.Game = g.name
+	m.logger.Printf("Recorded CRC \"%s\" against \"%s\"\n", r.CRC, g.name)
+
+	return r, nil
+}
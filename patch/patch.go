@@ -0,0 +1,304 @@
+/*
+Package patch applies the IPS, BPS and UPS patches used to distribute
+translations and hacks of ROM images.
+
+The checksums embedded in BPS and UPS patches are verified so a patch applied
+to the wrong ROM image is rejected.
+*/
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+var (
+	ipsMagic = []byte("PATCH")
+	ipsEOF   = []byte("EOF")
+	bpsMagic = []byte("BPS1")
+	upsMagic = []byte("UPS1")
+)
+
+// Errors returned when a patch can't be applied
+var (
+	ErrFormat         = errors.New("patch: unknown patch format")
+	ErrCorrupt        = errors.New("patch: patch is corrupt")
+	ErrSourceChecksum = errors.New("patch: source checksum mismatch")
+	ErrTargetChecksum = errors.New("patch: target checksum mismatch")
+)
+
+// Format is the format of a patch
+type Format int
+
+// Each supported patch format
+const (
+	FormatUnknown Format = iota
+	FormatIPS
+	FormatBPS
+	FormatUPS
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatIPS:
+		return "IPS"
+	case FormatBPS:
+		return "BPS"
+	case FormatUPS:
+		return "UPS"
+	}
+	return "Unknown"
+}
+
+// Detect returns the format of the patch p
+func Detect(p []byte) Format {
+	switch {
+	case bytes.HasPrefix(p, ipsMagic):
+		return FormatIPS
+	case bytes.HasPrefix(p, bpsMagic):
+		return FormatBPS
+	case bytes.HasPrefix(p, upsMagic):
+		return FormatUPS
+	}
+	return FormatUnknown
+}
+
+// Apply applies the patch p to the source ROM image and returns the patched
+// image. The format of the patch is detected automatically
+func Apply(source, p []byte) ([]byte, error) {
+	switch Detect(p) {
+	case FormatIPS:
+		return applyIPS(source, p)
+	case FormatBPS:
+		return applyBPS(source, p)
+	case FormatUPS:
+		return applyUPS(source, p)
+	}
+	return nil, ErrFormat
+}
+
+func applyIPS(source, p []byte) ([]byte, error) {
+	target := append([]byte(nil), source...)
+
+	p = p[len(ipsMagic):]
+	for {
+		if len(p) < 3 {
+			return nil, ErrCorrupt
+		}
+		if bytes.Equal(p[:3], ipsEOF) {
+			p = p[3:]
+			break
+		}
+		if len(p) < 5 {
+			return nil, ErrCorrupt
+		}
+
+		offset := int(p[0])<<16 | int(p[1])<<8 | int(p[2])
+		size := int(binary.BigEndian.Uint16(p[3:5]))
+		p = p[5:]
+
+		var data []byte
+		if size == 0 {
+			// Run-length encoded record
+			if len(p) < 3 {
+				return nil, ErrCorrupt
+			}
+			data = bytes.Repeat(p[2:3], int(binary.BigEndian.Uint16(p[:2])))
+			p = p[3:]
+		} else {
+			if len(p) < size {
+				return nil, ErrCorrupt
+			}
+			data, p = p[:size], p[size:]
+		}
+
+		if n := offset + len(data); n > len(target) {
+			target = append(target, make([]byte, n-len(target))...)
+		}
+		copy(target[offset:], data)
+	}
+
+	// An optional truncation offset may follow the end marker
+	if len(p) >= 3 {
+		if size := int(p[0])<<16 | int(p[1])<<8 | int(p[2]); size < len(target) {
+			target = target[:size]
+		}
+	}
+
+	return target, nil
+}
+
+// decoder reads the variable-length integers used by BPS and UPS
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) byte() byte {
+	if len(d.b) == 0 {
+		d.err = ErrCorrupt
+		return 0
+	}
+	c := d.b[0]
+	d.b = d.b[1:]
+	return c
+}
+
+func (d *decoder) number() int {
+	var n, shift uint64 = 0, 1
+	for d.err == nil {
+		c := d.byte()
+		n += uint64(c&0x7f) * shift
+		if c&0x80 != 0 {
+			break
+		}
+		shift <<= 7
+		n += shift
+	}
+	return int(n)
+}
+
+// footer splits the patch into its body and the three CRC-32 checksums of
+// the source, target and patch, verifying the latter
+func footer(p []byte, magic []byte) ([]byte, uint32, uint32, error) {
+	if len(p) < len(magic)+12 {
+		return nil, 0, 0, ErrCorrupt
+	}
+	f := p[len(p)-12:]
+	if crc32.ChecksumIEEE(p[:len(p)-4]) != binary.LittleEndian.Uint32(f[8:]) {
+		return nil, 0, 0, ErrCorrupt
+	}
+	return p[len(magic) : len(p)-12], binary.LittleEndian.Uint32(f[0:]), binary.LittleEndian.Uint32(f[4:]), nil
+}
+
+func applyBPS(source, p []byte) ([]byte, error) {
+	body, sourceCRC, targetCRC, err := footer(p, bpsMagic)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decoder{b: body}
+	sourceSize := d.number()
+	targetSize := d.number()
+	metadataSize := d.number()
+	if d.err != nil || metadataSize > len(d.b) {
+		return nil, ErrCorrupt
+	}
+	d.b = d.b[metadataSize:]
+
+	if sourceSize != len(source) || crc32.ChecksumIEEE(source) != sourceCRC {
+		return nil, ErrSourceChecksum
+	}
+
+	target := make([]byte, targetSize)
+	var output, sourceRelative, targetRelative int
+	for len(d.b) > 0 && d.err == nil {
+		data := d.number()
+		length := data>>2 + 1
+		if output+length > targetSize {
+			return nil, ErrCorrupt
+		}
+
+		switch data & 3 {
+		case 0: // SourceRead
+			if output+length > len(source) {
+				return nil, ErrCorrupt
+			}
+			copy(target[output:], source[output:output+length])
+		case 1: // TargetRead
+			if length > len(d.b) {
+				return nil, ErrCorrupt
+			}
+			copy(target[output:], d.b[:length])
+			d.b = d.b[length:]
+		case 2: // SourceCopy
+			sourceRelative += offset(d.number())
+			if sourceRelative < 0 || sourceRelative+length > len(source) {
+				return nil, ErrCorrupt
+			}
+			copy(target[output:], source[sourceRelative:sourceRelative+length])
+			sourceRelative += length
+		case 3: // TargetCopy
+			targetRelative += offset(d.number())
+			if targetRelative < 0 || targetRelative >= output {
+				return nil, ErrCorrupt
+			}
+			// The regions can overlap so this has to be byte by byte
+			for i := 0; i < length; i++ {
+				target[output+i] = target[targetRelative]
+				targetRelative++
+			}
+		}
+		output += length
+	}
+	if d.err != nil || output != targetSize {
+		return nil, ErrCorrupt
+	}
+
+	if crc32.ChecksumIEEE(target) != targetCRC {
+		return nil, ErrTargetChecksum
+	}
+
+	return target, nil
+}
+
+// offset decodes a signed relative offset where the lowest bit is the sign
+func offset(n int) int {
+	if n&1 != 0 {
+		return -(n >> 1)
+	}
+	return n >> 1
+}
+
+func applyUPS(source, p []byte) ([]byte, error) {
+	body, sourceCRC, targetCRC, err := footer(p, upsMagic)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decoder{b: body}
+	sourceSize := d.number()
+	targetSize := d.number()
+	if d.err != nil {
+		return nil, ErrCorrupt
+	}
+
+	if sourceSize != len(source) || crc32.ChecksumIEEE(source) != sourceCRC {
+		return nil, ErrSourceChecksum
+	}
+
+	target := make([]byte, targetSize)
+	copy(target, source)
+
+	var output int
+	for len(d.b) > 0 && d.err == nil {
+		output += d.number()
+		for d.err == nil {
+			c := d.byte()
+			if c == 0 {
+				output++
+				break
+			}
+			if output >= targetSize {
+				return nil, ErrCorrupt
+			}
+			var s byte
+			if output < len(source) {
+				s = source[output]
+			}
+			target[output] = s ^ c
+			output++
+		}
+	}
+	if d.err != nil {
+		return nil, ErrCorrupt
+	}
+
+	if crc32.ChecksumIEEE(target) != targetCRC {
+		return nil, ErrTargetChecksum
+	}
+
+	return target, nil
+}
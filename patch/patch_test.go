@@ -0,0 +1,115 @@
+package patch
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func number(n int) []byte {
+	var b []byte
+	for {
+		x := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return append(b, x|0x80)
+		}
+		b = append(b, x)
+		n--
+	}
+}
+
+func withFooter(p, source, target []byte) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], crc32.ChecksumIEEE(source))
+	p = append(p, b[:]...)
+	binary.LittleEndian.PutUint32(b[:], crc32.ChecksumIEEE(target))
+	p = append(p, b[:]...)
+	binary.LittleEndian.PutUint32(b[:], crc32.ChecksumIEEE(p))
+	return append(p, b[:]...)
+}
+
+func TestIPS(t *testing.T) {
+	source := []byte("Hello, World")
+	p := []byte("PATCH")
+	p = append(p, 0x00, 0x00, 0x07, 0x00, 0x05, 'M', 'o', 'o', 'n', '!')
+	p = append(p, 0x00, 0x00, 0x0c, 0x00, 0x00, 0x00, 0x03, '!')
+	p = append(p, "EOF"...)
+
+	target, err := Apply(source, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []byte("Hello, Moon!!!!"), target)
+
+	// Truncate back down
+	target, err = Apply(source, append(p, 0x00, 0x00, 0x05))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []byte("Hello"), target)
+}
+
+func TestBPS(t *testing.T) {
+	source := []byte("Hello, World")
+	target := []byte("Hello, Hello!")
+
+	p := []byte("BPS1")
+	p = append(p, number(len(source))...)
+	p = append(p, number(len(target))...)
+	p = append(p, number(0)...)
+	p = append(p, number((7-1)<<2|0)...) // SourceRead "Hello, "
+	p = append(p, number((5-1)<<2|3)...) // TargetCopy "Hello"
+	p = append(p, number(0)...)
+	p = append(p, number((1-1)<<2|1)...) // TargetRead "!"
+	p = append(p, '!')
+	p = withFooter(p, source, target)
+
+	b, err := Apply(source, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, target, b)
+
+	_, err = Apply([]byte("Hello, world"), p)
+	assert.Equal(t, ErrSourceChecksum, err)
+
+	p[len(p)-13] = '?'
+	_, err = Apply(source, p)
+	assert.Equal(t, ErrCorrupt, err)
+}
+
+func TestUPS(t *testing.T) {
+	source := []byte("Hello, World")
+	target := []byte("Hello, Jazz!!")
+
+	p := []byte("UPS1")
+	p = append(p, number(len(source))...)
+	p = append(p, number(len(target))...)
+	p = append(p, number(7)...)
+	for i := 7; i < len(target); i++ {
+		var s byte
+		if i < len(source) {
+			s = source[i]
+		}
+		p = append(p, s^target[i])
+	}
+	p = append(p, 0)
+	p = withFooter(p, source, target)
+
+	b, err := Apply(source, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, target, b)
+
+	_, err = Apply(target, p)
+	assert.Equal(t, ErrSourceChecksum, err)
+}
+
+func TestUnknown(t *testing.T) {
+	_, err := Apply(nil, []byte("NOTAPATCH"))
+	assert.Equal(t, ErrFormat, err)
+}
@@ -3,42 +3,94 @@ package megasd
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 
 	"github.com/bodgit/megasd/metadata"
+	"github.com/bodgit/megasd/rom"
 )
 
-func containsCue(dir string) (bool, error) {
-	d, err := os.Open(dir)
-	if err != nil {
-		return false, err
-	}
-	defer d.Close()
+// candidate is a game as presented by the MegaSD when browsing a directory
+type candidate struct {
+	name   string // Name hashed by the firmware
+	path   string // ROM image or cue sheet
+	system *System
+	folder bool // The game lives in its own directory, such as a CD
+}
 
-	info, err := d.Stat()
-	if err != nil {
-		return false, err
-	}
+// cd reports whether the game lives in its own directory, such as a CD or
+// an MSU-MD game
+func (c candidate) cd() bool {
+	return c.folder
+}
+
+// crc returns the CRC used to look up the game in the database
+func (c candidate) crc() (string, error) {
+	return c.system.Hash(c.path)
+}
+
+func isROM(file string) bool {
+	s := lookupSystem(file)
+	return s != nil && s.Layout == LayoutFile
+}
 
-	if !info.IsDir() {
-		return false, errors.New("not a directory")
+// candidates returns the games in dir that would be looked up in its
+// metadata database, using the same rules as fileWorker
+func candidates(dir string) ([]candidate, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
 	}
 
-	files, err := d.Readdirnames(0)
+	cues := newCueCache()
+	d, err := cues.dir(dir)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	for _, file := range files {
-		if filepath.Ext(file) == ".cue" {
-			return true, nil
+	var c []candidate
+	for _, info := range files {
+		// Ignore any hidden files or directories
+		if info.Name()[0] == '.' {
+			continue
+		}
+
+		switch {
+		case info.Mode().IsDir():
+			sub, err := cues.dir(filepath.Join(dir, info.Name()))
+			if err != nil {
+				return nil, err
+			}
+			cue, s := sub.first()
+			if cue == "" {
+				continue
+			}
+			// An MSU-MD game is identified by its ROM image
+			if msu, ms := sub.msuROM(); msu != "" {
+				cue, s = msu, ms
+			}
+			c = append(c, candidate{info.Name(), cue, s, true})
+		case info.Mode().IsRegular():
+			s := lookupSystem(info.Name())
+			if s == nil || d.isTrack(info.Name()) {
+				continue
+			}
+			// Each disc of a multi-disc game is listed inside its directory
+			if s.Layout != LayoutFile {
+				if msu, _ := d.msuROM(); !isCue(info.Name()) || len(d.cues) < 2 || msu != "" {
+					continue
+				}
+			}
+			c = append(c, candidate{strings.TrimSuffix(info.Name(), filepath.Ext(info.Name())), filepath.Join(dir, info.Name()), s, false})
 		}
 	}
 
-	return false, nil
+	return c, nil
 }
 
 func (m *MegaSD) findDirectories(ctx context.Context, base string) (<-chan string, <-chan error, error) {
@@ -77,58 +129,196 @@ func (m *MegaSD) findDirectories(ctx context.Context, base string) (<-chan strin
 	return out, errc, nil
 }
 
-func (m *MegaSD) fileWorker(dir, file string, db *metadata.DB) error {
-	var crc string
-	var err error
-	switch filepath.Ext(file) {
-	case ".bin":
-		// For any .bin file, if there is a .cue file in the same directory, assume it's a CD track rather than a ROM image
-		hasCue, err := containsCue(filepath.Dir(file))
-		if err != nil {
-			return err
-		}
-		if hasCue {
+func romHeader(file string) (*rom.Header, error) {
+	h, _, err := ROMHeader(file)
+	return h, err
+}
+
+func discHeader(file string) (*rom.Header, error) {
+	h, err := DiscHeader(file)
+	if err != nil {
+		return nil, err
+	}
+	return &h.Header, nil
+}
+
+func (m *MegaSD) fileWorker(dir, file string, s *System, db *metadata.DB, o *scanOptions, r *Report) error {
+	d, err := o.cues.dir(filepath.Dir(file))
+	if err != nil {
+		return err
+	}
+
+	var name string
+	hashTracks := o.hashTracks
+	switch s.Layout {
+	case LayoutFile:
+		// Any file referenced by a cue sheet is a CD track rather than a ROM image
+		if d.isTrack(file) {
 			return nil
 		}
-		fallthrough
-	case ".32x", ".md", ".sg", ".sms":
 		// Check files are in the "top" directory
 		if filepath.Dir(file) != dir {
 			return nil
 		}
-		crc, err = crcFile(file)
-		if err != nil {
-			return err
+		name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	case LayoutDirectory:
+		first, _ := d.first()
+		msu, ms := d.msuROM()
+
+		switch {
+		case filepath.Dir(filepath.Dir(file)) == dir:
+			// Only the file the firmware would pick describes the game
+			if first != file {
+				return nil
+			}
+			name = filepath.Base(filepath.Dir(file))
+
+			// An MSU-MD game is presented as a directory but is identified
+			// by its ROM image
+			if msu != "" {
+				crc, err := ms.Hash(msu)
+				if err != nil {
+					return err
+				}
+				m.logger.Printf("Found MSU-MD game \"%s\", with CRC \"%s\"\n", msu, crc)
+				return m.matchGame(msu, name, crc, nil, ms, db, o, r)
+			}
+
+			if s.System == rom.SystemMegaCD {
+				if err := m.checkDiscs(d, r); err != nil {
+					return err
+				}
+			}
+		case filepath.Dir(file) == dir && isCue(file) && len(d.cues) > 1 && msu == "":
+			// Each disc of a multi-disc game is listed inside its
+			// directory under the name of its cue sheet
+			name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+			// The first disc is already hashed for its parent directory
+			hashTracks = hashTracks && first != file
+		default:
+			return nil
 		}
+	default:
+		return nil
+	}
+
+	crc, err := s.Hash(file)
+	if err != nil {
+		return err
+	}
 
-		screenshot, err := m.db.FindScreenshotByCRC(crc)
+	// The tracks are hashed first so the disc can be matched by them
+	var tracks []TrackHash
+	if hashTracks && s.System == rom.SystemMegaCD && crc != "" {
+		if tracks, err = m.HashDisc(file, o.allTracks); err != nil {
+			m.logger.Printf("Unable to hash \"%s\": %s\n", file, err)
+		} else {
+			r.addDisc(Disc{
+				File:   file,
+				CRC:    crc,
+				Tracks: tracks,
+			})
+		}
+	}
+
+	return m.matchGame(file, name, crc, tracks, s, db, o, r)
+}
+
+// matchGame looks up the game by the Redump hash of any track of a CD if
+// they were hashed, then by its CRC, falling back to the serial in its
+// header and then optionally its name, and stores the screenshot under the
+// given name
+func (m *MegaSD) matchGame(file, name, crc string, tracks []TrackHash, s *System, db *metadata.DB, o *scanOptions, r *Report) error {
+	// Not every game has a header so any error is ignored
+	h, _ := s.Header(file)
+
+	// The hash of a whole track tells apart revisions of a CD which share
+	// the CRC of their first data sector
+	if len(tracks) > 0 {
+		g, err := m.db.findGameByTracks(tracks)
 		if err != nil {
 			return err
 		}
-		if screenshot != nil {
-			return db.Set(metadata.CRCFilename(strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))), screenshot)
+
+		if g != nil && g.screenshot != nil {
+			m.logger.Printf("Matched \"%s\", with CRC \"%s\", to \"%s\" by its Redump track hash\n", file, crc, g.name)
+			return db.Set(metadata.CRCFilename(name), g.screenshot)
 		}
-	case ".cue":
-		if filepath.Dir(filepath.Dir(file)) != dir {
+	}
+
+	g, err := m.db.findGameByCRC(crc)
+	if err != nil {
+		return err
+	}
+
+	if g != nil {
+		if err := m.learnSerial(g.id, h); err != nil {
+			return err
+		}
+
+		if g.screenshot == nil {
 			return nil
 		}
-		crc, err = crcCueFile(file)
+		return db.Set(metadata.CRCFilename(name), g.screenshot)
+	}
+
+	if h != nil && h.ProductCode() != "" {
+		g, err := m.db.findGameBySerial(h.ProductCode(), h.Regions, h.System)
 		if err != nil {
 			return err
 		}
 
-		screenshot, err := m.db.FindScreenshotByCRC(crc)
+		if g != nil && g.screenshot != nil {
+			m.logger.Printf("Matched \"%s\", with CRC \"%s\", to \"%s\" by serial \"%s\"\n", file, crc, g.name, h.ProductCode())
+			r.addSerialMatch(SerialMatch{
+				File:   file,
+				CRC:    crc,
+				Serial: h.ProductCode(),
+				Region: h.Regions,
+				Game:   g.name,
+			})
+			return db.Set(metadata.CRCFilename(name), g.screenshot)
+		}
+	}
+
+	if o.matchNames {
+		games, err := o.names.load(m.db)
 		if err != nil {
 			return err
 		}
-		if screenshot != nil {
-			return db.Set(metadata.CRCFilename(filepath.Base(filepath.Dir(file))), screenshot)
+
+		g, score, err := m.db.findGameByName(games, name, s.System, o.nameThreshold)
+		if err != nil {
+			return err
+		}
+
+		if g != nil {
+			m.logger.Printf("Matched \"%s\", with CRC \"%s\", to \"%s\" by name with score %.2f\n", file, crc, g.name, score)
+			nm := NameMatch{
+				File:  file,
+				CRC:   crc,
+				Game:  g.name,
+				Score: score,
+			}
+			// A game with no DAT entry or serial could be for any system
+			// so its CRC is never learned
+			if o.learnNames && score >= o.learnThreshold && crc != "" && g.system {
+				if err := m.db.addChecksum(g.id, crc); err != nil {
+					return err
+				}
+				nm.Learned = true
+			}
+			r.addNameMatch(nm)
+			return db.Set(metadata.CRCFilename(name), g.screenshot)
 		}
-	default:
-		return nil
 	}
 
 	m.logger.Printf("No match for \"%s\", with CRC \"%s\"\n", file, crc)
+	r.addUnmatched(Unmatched{
+		File:   file,
+		CRC:    crc,
+		Header: h,
+	})
 
 	return nil
 }
@@ -152,7 +342,25 @@ func writeMetadata(dir string, db *metadata.DB) error {
 	return nil
 }
 
-func (m *MegaSD) directoryWorker(ctx context.Context, in <-chan string) (<-chan error, error) {
+func readMetadata(dir string) (*metadata.DB, error) {
+	db := metadata.New()
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, metadata.Filename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return db, nil
+		}
+		return nil, err
+	}
+
+	if err := db.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func (m *MegaSD) directoryWorker(ctx context.Context, in <-chan string, o *scanOptions, r *Report) (<-chan error, error) {
 	errc := make(chan error, 1)
 	go func() {
 		defer close(errc)
@@ -176,12 +384,13 @@ func (m *MegaSD) directoryWorker(ctx context.Context, in <-chan string) (<-chan
 					return nil
 				}
 
-				// Ignore any file greater than 16 MB
-				if info.Size() > 16<<(10*2) {
+				// Ignore any file not belonging to a system or that is too big
+				s := lookupSystem(file)
+				if s == nil || (s.MaxSize > 0 && info.Size() > s.MaxSize) {
 					return nil
 				}
 
-				if err := m.fileWorker(dir, file, db); err != nil {
+				if err := m.fileWorker(dir, file, s, db, o, r); err != nil {
 					return err
 				}
 
@@ -191,6 +400,11 @@ func (m *MegaSD) directoryWorker(ctx context.Context, in <-chan string) (<-chan
 				return
 			}
 
+			if err := m.trimMetadata(dir, db, r); err != nil {
+				errc <- err
+				return
+			}
+
 			if db.Length() > 0 {
 				if err := writeMetadata(dir, db); err != nil {
 					errc <- err
@@ -231,32 +445,150 @@ func mergeErrors(cs ...<-chan error) <-chan error {
 	return out
 }
 
+type scanOptions struct {
+	renameCollisions bool
+	hashTracks       bool
+	allTracks        bool
+	splitDirectories bool
+	matchNames       bool
+	nameThreshold    float64
+	learnNames       bool
+	learnThreshold   float64
+	log              io.Writer
+	cues             *cueCache
+	names            *gameNames
+}
+
+// ScanOption configures optional behaviour of Scan
+type ScanOption func(*scanOptions)
+
+// RenameCollisions renames any files or CD directories whose names hash to
+// the same CRC as another game in the same directory so that every game
+// keeps its own screenshot
+func RenameCollisions() ScanOption {
+	return func(o *scanOptions) {
+		o.renameCollisions = true
+	}
+}
+
+// HashTracks also hashes the data track of every CD, or every track if all
+// is true, in the same form as Redump and matches them against any imported
+// Redump DAT files. This reads every track in full so is much slower
+func HashTracks(all bool) ScanOption {
+	return func(o *scanOptions) {
+		o.hashTracks = true
+		o.allTracks = all
+	}
+}
+
+// SplitDirectories moves the games in any directory with more than the
+// metadata.MaxEntries games its metadata can hold into alphabetical
+// sub-directories, such as "A-C" and "D-F". Otherwise only a subset of the
+// games in such a directory get a screenshot. This happens before any game
+// is identified so every file that looks like a game is counted, even if
+// it won't match anything
+func SplitDirectories() ScanOption {
+	return func(o *scanOptions) {
+		o.splitDirectories = true
+	}
+}
+
+// SplitLog writes each move made by SplitDirectories to w as it happens,
+// in the same form as UndoLog so they can be reversed with Undo
+func SplitLog(w io.Writer) ScanOption {
+	return func(o *scanOptions) {
+		o.log = w
+	}
+}
+
+// MatchNames falls back to matching any game whose CRC and serial don't
+// match anything by comparing its normalized name with the name of every
+// game, ignoring any region, revision or other tags, punctuation and
+// articles. A match needs a similarity score, greater than 0 and up to 1,
+// of at least threshold
+func MatchNames(threshold float64) ScanOption {
+	return func(o *scanOptions) {
+		o.matchNames = true
+		o.nameThreshold = threshold
+	}
+}
+
+// LearnNames adds the CRC of each game matched by MatchNames with a score
+// of at least threshold to the database so later scans match it exactly.
+// Anything less than 1 risks learning the wrong game for good. Only games
+// with a DAT entry or serial for the same system are learned
+func LearnNames(threshold float64) ScanOption {
+	return func(o *scanOptions) {
+		o.learnNames = true
+		o.learnThreshold = threshold
+	}
+}
+
 // Scan traverses the given directory and creates a metadata in any
 // sub-directory that contains matching images
-func (m *MegaSD) Scan(path string) error {
+func (m *MegaSD) Scan(path string, opts ...ScanOption) (*Report, error) {
 	dir, err := filepath.Abs(path)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	o := new(scanOptions)
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.matchNames && (o.nameThreshold <= 0 || o.nameThreshold > 1) {
+		return nil, fmt.Errorf("name threshold %g isn't greater than 0 and up to 1", o.nameThreshold)
+	}
+	if o.learnNames && (o.learnThreshold <= 0 || o.learnThreshold > 1) {
+		return nil, fmt.Errorf("learn threshold %g isn't greater than 0 and up to 1", o.learnThreshold)
+	}
+	r := new(Report)
+
+	// Any moving or renaming has to happen before the directories are walked
+	if err := m.checkLargeDirectories(dir, o, r); err != nil {
+		return nil, err
+	}
+
+	if err := m.checkAllCollisions(dir, o, r); err != nil {
+		return nil, err
 	}
 
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
 
-	var errcList []<-chan error
-
 	dirs, errc, err := m.findDirectories(ctx, dir)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if err := m.scanDirectories(ctx, dirs, errc, o, r); err != nil {
+		return nil, err
 	}
-	errcList = append(errcList, errc)
+
+	return r, nil
+}
+
+// scanDirectories creates the metadata in each directory received from dirs
+func (m *MegaSD) scanDirectories(ctx context.Context, dirs <-chan string, errc <-chan error, o *scanOptions, r *Report) error {
+	errcList := []<-chan error{errc}
+
+	// Each directory is parsed once for every pass over the filesystem
+	o.cues = newCueCache()
+	o.names = new(gameNames)
 
 	for i := 0; i < 10; i++ {
-		errc, err := m.directoryWorker(ctx, dirs)
+		errc, err := m.directoryWorker(ctx, dirs, o, r)
 		if err != nil {
 			return err
 		}
 		errcList = append(errcList, errc)
 	}
 
-	return waitForPipeline(errcList...)
+	if err := waitForPipeline(errcList...); err != nil {
+		return err
+	}
+	r.sort()
+
+	return nil
 }
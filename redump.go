@@ -0,0 +1,331 @@
+package megasd
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bodgit/megasd/cd"
+	"github.com/bodgit/megasd/cue"
+	"github.com/bodgit/megasd/rom"
+)
+
+// trackExtent locates a single track of a disc within its file
+type trackExtent struct {
+	file   string
+	kind   string // File type, such as "BINARY" or "WAVE"
+	track  *cue.Track
+	offset int64 // Offset of the first sector, including any INDEX 00
+	length int64 // Length in bytes as stored in the file
+	lba    int   // Logical block address of the first sector
+}
+
+// sectors returns the number of sectors in the track
+func (t trackExtent) sectors() int {
+	return int(t.length / int64(t.track.SectorSize()))
+}
+
+// wavData returns the offset and length of the PCM data in a WAV file
+func wavData(file string) (int64, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var riff [12]byte
+	if _, err := io.ReadFull(f, riff[:]); err != nil {
+		return 0, 0, err
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return 0, 0, errors.New("not a WAV file")
+	}
+
+	offset := int64(len(riff))
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(f, chunk[:]); err != nil {
+			return 0, 0, err
+		}
+		offset += int64(len(chunk))
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		if string(chunk[0:4]) == "data" {
+			return offset, size, nil
+		}
+		// Chunks are padded to an even size
+		size += size & 1
+		if _, err := f.Seek(size, io.SeekCurrent); err != nil {
+			return 0, 0, err
+		}
+		offset += size
+	}
+}
+
+// discExtents returns where every track of the disc described by the cue
+// sheet, CloneCD control file or ISO image is stored. Tracks in lossy audio
+// formats can't be located so have a zero length
+func discExtents(file string) ([]trackExtent, error) {
+	if isCCD(file) {
+		return ccdExtents(file)
+	}
+	if !isCue(file) {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		return []trackExtent{{
+			file:   file,
+			kind:   "BINARY",
+			track:  &cue.Track{Number: 1, Mode: cue.Mode1Cooked},
+			length: info.Size() - info.Size()%cd.DataSize,
+		}}, nil
+	}
+
+	sheet, err := cue.ParseFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var extents []trackExtent
+	var lba int
+	for _, f := range sheet.Files {
+		path := filepath.Join(filepath.Dir(file), f.Name)
+
+		var base, size int64
+		switch f.Type {
+		case "BINARY", "MOTOROLA", "":
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			size = info.Size()
+		case "WAVE":
+			if base, size, err = wavData(path); err != nil {
+				return nil, err
+			}
+		default:
+			// The original audio can't be recovered from a lossy format
+			for _, t := range f.Tracks {
+				extents = append(extents, trackExtent{file: path, kind: f.Type, track: t, lba: lba})
+			}
+			continue
+		}
+
+		for i, t := range f.Tracks {
+			if t.SectorSize() == 0 {
+				return nil, fmt.Errorf("unsupported track mode \"%s\"", t.Mode)
+			}
+
+			start, err := f.Offset(t, firstIndex(t))
+			if err != nil {
+				return nil, err
+			}
+			end := size
+			if i+1 < len(f.Tracks) {
+				next := f.Tracks[i+1]
+				if end, err = f.Offset(next, firstIndex(next)); err != nil {
+					return nil, err
+				}
+			}
+			if end > size || end < start {
+				return nil, fmt.Errorf("track %d extends beyond \"%s\"", t.Number, f.Name)
+			}
+
+			lba += t.Pregap
+			e := trackExtent{
+				file:   path,
+				kind:   f.Type,
+				track:  t,
+				offset: base + start,
+				length: end - start,
+				lba:    lba,
+			}
+			extents = append(extents, e)
+			lba += e.sectors() + t.Postgap
+		}
+	}
+
+	return extents, nil
+}
+
+// firstIndex returns the frame of the first index of the track
+func firstIndex(t *cue.Track) int {
+	if len(t.Indexes) > 0 {
+		return t.Indexes[0].Frame
+	}
+	return 0
+}
+
+// TrackHash is the hash of a single track in the same form as Redump, which
+// is the raw 2352 byte sectors
+type TrackHash struct {
+	Track int
+	Size  int64
+	CRC   string
+	MD5   string
+	SHA1  string
+	// Name is the name of the matching Redump DAT entry, if any
+	Name string
+	// Skipped explains why a track couldn't be hashed, such as when it is
+	// stored in a lossy audio format
+	Skipped string
+}
+
+// hashTrack hashes the track in Redump form. Cooked sectors are expanded
+// back into raw sectors with their header, EDC and ECC
+func hashTrack(e trackExtent) (*TrackHash, error) {
+	th := &TrackHash{Track: e.track.Number}
+	if e.length == 0 {
+		th.Skipped = fmt.Sprintf("%s audio can't be hashed", e.kind)
+		return th, nil
+	}
+
+	f, err := os.Open(e.file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c, m, s := crc32.NewIEEE(), md5.New(), sha1.New()
+	w := io.MultiWriter(c, m, s)
+	r := bufio.NewReader(io.NewSectionReader(f, e.offset, e.length))
+
+	switch {
+	case e.track.Mode == cue.Mode1Cooked:
+		data := make([]byte, cd.DataSize)
+		for i := 0; i < e.sectors(); i++ {
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, err
+			}
+			if _, err := w.Write(cd.Expand(data, e.lba+i)); err != nil {
+				return nil, err
+			}
+		}
+		th.Size = int64(e.sectors()) * cd.SectorSize
+	case e.track.SectorSize() != cd.SectorSize:
+		th.Skipped = fmt.Sprintf("%s tracks can't be hashed", e.track.Mode)
+		return th, nil
+	case e.kind == "MOTOROLA" && !e.track.IsData():
+		// Big-endian audio needs swapping to match a raw rip
+		b := make([]byte, cd.SectorSize)
+		for i := 0; i < e.sectors(); i++ {
+			if _, err := io.ReadFull(r, b); err != nil {
+				return nil, err
+			}
+			if _, err := w.Write(rom.SwapBytes(b)); err != nil {
+				return nil, err
+			}
+		}
+		th.Size = int64(e.sectors()) * cd.SectorSize
+	default:
+		if th.Size, err = io.Copy(w, r); err != nil {
+			return nil, err
+		}
+	}
+
+	th.CRC = hashString(c)
+	th.MD5 = hashString(m)
+	th.SHA1 = hashString(s)
+
+	return th, nil
+}
+
+func hashString(h hash.Hash) string {
+	return strings.ToUpper(fmt.Sprintf("%x", h.Sum(nil)))
+}
+
+// findTrackDAT returns the ID and name of the Mega CD DAT entry with the
+// given SHA-1, or zero and an empty string if there isn't one
+func (db *gameDB) findTrackDAT(sha1 string) (int64, string, error) {
+	var id int64
+	var name string
+	switch err := db.db.QueryRow("SELECT id, name FROM dat WHERE sha1 = ? AND system = ? ORDER BY name LIMIT 1", sha1, rom.SystemMegaCD.String()).Scan(&id, &name); err {
+	case nil, sql.ErrNoRows:
+		return id, name, nil
+	default:
+		return 0, "", err
+	}
+}
+
+// findGameByTracks finds the game linked to the Redump DAT entry matching
+// the hash of a track, trying each track in order. Unlike the CRC used by
+// the firmware, the hash of the whole data track differs between revisions
+// of the same disc
+func (db *gameDB) findGameByTracks(tracks []TrackHash) (*gameMatch, error) {
+	for _, th := range tracks {
+		if th.Skipped != "" {
+			continue
+		}
+		g, err := db.findGame("SELECT g.id, g.name, g.year, g.genre, s.data, NULL FROM dat AS d JOIN game AS g ON d.game_id = g.id LEFT JOIN screenshot AS s ON g.screenshot_id = s.id WHERE d.sha1 = ? AND d.system = ? ORDER BY s.data IS NULL, d.name LIMIT 1", th.SHA1, rom.SystemMegaCD.String())
+		if err != nil || g != nil {
+			return g, err
+		}
+	}
+	return nil, nil
+}
+
+func (db *gameDB) addTrackHash(th *TrackHash, dat int64) error {
+	_, err := db.db.Exec("INSERT OR REPLACE INTO track_hash (sha1, size, track_crc, md5, dat_id) VALUES (?, ?, ?, ?, ?)", th.SHA1, th.Size, th.CRC, th.MD5, nullInt64(int(dat)))
+	return err
+}
+
+// HashDisc hashes the data track, or every track if all is true, of the disc
+// described by the cue sheet or ISO image in the same form as Redump. The
+// hashes are matched against any imported Redump DAT files and stored
+// along with the DAT entry they match
+func (m *MegaSD) HashDisc(file string, all bool) ([]TrackHash, error) {
+	crc, err := crcDiscFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if crc == "" {
+		return nil, errors.New("unable to read the first data sector")
+	}
+
+	extents, err := discExtents(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []TrackHash
+	for _, e := range extents {
+		if !all && e.track.Mode != cue.Mode1Cooked && e.track.Mode != cue.Mode1Raw {
+			continue
+		}
+
+		th, err := hashTrack(e)
+		if err != nil {
+			return nil, err
+		}
+
+		if th.Skipped == "" {
+			var id int64
+			if id, th.Name, err = m.db.findTrackDAT(th.SHA1); err != nil {
+				return nil, err
+			}
+			if err := m.db.addTrackHash(th, id); err != nil {
+				return nil, err
+			}
+			m.logger.Printf("Hashed track %d of \"%s\", with SHA-1 \"%s\"\n", th.Track, file, th.SHA1)
+		}
+
+		hashes = append(hashes, *th)
+
+		// Only the first data track identifies the disc
+		if !all {
+			break
+		}
+	}
+
+	return hashes, nil
+}
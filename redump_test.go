@@ -0,0 +1,67 @@
+package megasd
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/bodgit/megasd/metadata"
+	"github.com/bodgit/megasd/rom"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchGameByTracks(t *testing.T) {
+	m, cleanup := newTestMegaSD(t)
+	defer cleanup()
+
+	// Two revisions whose first data sectors, and so firmware CRCs, match
+	var games []int64
+	var screenshots [][]byte
+	for i, name := range []string{"Sonic CD (USA)", "Sonic CD (USA) (Rev 1)"} {
+		screenshot := make([]byte, metadata.ScreenshotSize)
+		screenshot[0] = byte(i + 1)
+		id, err := m.db.addScreenshotData(screenshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		game, err := m.db.addGame(name, sql.NullInt64{}, sql.NullInt64{}, sql.NullInt64{Int64: id, Valid: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.db.db.Exec("INSERT INTO dat (system, name, region, revision, file, size, crc, md5, sha1, game_id) VALUES (?, ?, '', '', '', 0, '', '', ?, ?)", rom.SystemMegaCD.String(), name, fmtSHA1(i), game); err != nil {
+			t.Fatal(err)
+		}
+		games = append(games, game)
+		screenshots = append(screenshots, screenshot)
+	}
+	if err := m.db.addChecksum(games[0], "12345678"); err != nil {
+		t.Fatal(err)
+	}
+
+	s := lookupSystem("game.cue")
+	tables := []struct {
+		tracks     []TrackHash
+		screenshot []byte
+	}{
+		{nil, screenshots[0]},
+		{[]TrackHash{{Track: 1, SHA1: fmtSHA1(0)}}, screenshots[0]},
+		{[]TrackHash{{Track: 1, SHA1: fmtSHA1(1)}}, screenshots[1]},
+		{[]TrackHash{{Track: 1, SHA1: "unknown"}}, screenshots[0]},
+	}
+
+	for _, table := range tables {
+		db := metadata.New()
+		if err := m.matchGame("game.cue", "Sonic CD", "12345678", table.tracks, s, db, new(scanOptions), new(Report)); err != nil {
+			t.Fatal(err)
+		}
+		screenshot, ok := db.Get(metadata.CRCFilename("Sonic CD"))
+		// The genre and year are written into the screenshot so only
+		// compare the first byte that tells them apart
+		if assert.True(t, ok) {
+			assert.Equal(t, table.screenshot[0], screenshot[0])
+		}
+	}
+}
+
+func fmtSHA1(i int) string {
+	return []string{"5A1F9D0C1B2E3F405162738495A6B7C8D9E0F1A2", "6B2FAE1D2C3F40516273849506B7C8D9EAF10213"}[i]
+}
@@ -0,0 +1,445 @@
+package megasd
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bodgit/megasd/dat"
+	"github.com/bodgit/megasd/metadata"
+	"github.com/bodgit/megasd/rom"
+)
+
+// DefaultTemplate is the template used to rename games unless another is
+// given. The following fields are available:
+//
+//	{name}      the full canonical name, equivalent to {title} ({region}) ({revision}) {tags}
+//	{title}     the title without any tags
+//	{region}    the comma-separated regions
+//	{revision}  the revision, such as "Rev 1"
+//	{tags}      any other tags
+//
+// Any empty parentheses or brackets left behind are removed.
+const DefaultTemplate = "{name}"
+
+// canonicalName returns the canonical name for the given CRC. A ROM image
+// is looked up in the imported DAT files directly, otherwise the name comes
+// from any DAT linked to the matching game or failing that, the game itself
+func (db *gameDB) canonicalName(crc string, cd bool) (string, error) {
+	var name string
+
+	if !cd {
+		switch err := db.db.QueryRow("SELECT name FROM dat WHERE crc = ? AND system != ? AND size & 4095 = 0 ORDER BY name LIMIT 1", crc, rom.SystemMegaCD.String()).Scan(&name); err {
+		case nil:
+			return name, nil
+		case sql.ErrNoRows:
+		default:
+			return "", err
+		}
+	}
+
+	rows, err := db.db.Query("SELECT DISTINCT d.name FROM checksum AS c JOIN dat AS d ON c.game_id = d.game_id WHERE c.crc = ?", crc)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		if err := rows.Scan(&name); err != nil {
+			return "", err
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	// Only use the DAT if it's unambiguous
+	if len(names) == 1 {
+		return names[0], nil
+	}
+
+	switch err := db.db.QueryRow("SELECT g.name FROM checksum AS c JOIN game AS g ON c.game_id = g.id WHERE c.crc = ?", crc).Scan(&name); err {
+	case nil:
+		return name, nil
+	case sql.ErrNoRows:
+		return "", nil
+	default:
+		return "", err
+	}
+}
+
+// Characters not allowed in a FAT filename
+var invalidChars = strings.NewReplacer("<", "_", ">", "_", ":", " -", "\"", "'", "/", "-", "\\", "-", "|", "-", "?", "", "*", "_")
+
+var emptyTags = strings.NewReplacer("()", "", "[]", "")
+
+func revisionTag(revision string) string {
+	if revision == "" || strings.HasPrefix(revision, "v") {
+		return revision
+	}
+	return "Rev " + revision
+}
+
+func renderTemplate(template string, n dat.Name) string {
+	region := strings.Join(n.Regions, ", ")
+	tags := strings.Join(n.Tags, " ")
+
+	name := n.Title
+	if region != "" {
+		name += " (" + region + ")"
+	}
+	if n.Revision != "" {
+		name += " (" + revisionTag(n.Revision) + ")"
+	}
+	if tags != "" {
+		name += " " + tags
+	}
+
+	s := strings.NewReplacer(
+		"{name}", name,
+		"{title}", n.Title,
+		"{region}", region,
+		"{revision}", revisionTag(n.Revision),
+		"{tags}", tags,
+	).Replace(template)
+
+	return strings.Join(strings.Fields(emptyTags.Replace(invalidChars.Replace(s))), " ")
+}
+
+// canonicalFilename renders the template for the given canonical name. If
+// the result is longer than the firmware considers then tags are dropped,
+// least important first, and finally the title is shortened at a word
+// boundary
+func canonicalFilename(template, name string) string {
+	n := dat.ParseName(name)
+
+	s := renderTemplate(template, n)
+	for len(s) > filenameTrim && len(n.Tags) > 0 {
+		n.Tags = n.Tags[:len(n.Tags)-1]
+		s = renderTemplate(template, n)
+	}
+	if len(s) > filenameTrim && n.Revision != "" {
+		n.Revision = ""
+		s = renderTemplate(template, n)
+	}
+	if len(s) > filenameTrim && len(n.Regions) > 1 {
+		n.Regions = n.Regions[:1]
+		s = renderTemplate(template, n)
+	}
+
+	for len(s) > filenameTrim {
+		words := strings.Fields(n.Title)
+		if len(words) < 2 {
+			return strings.TrimSpace(truncate(s, filenameTrim))
+		}
+		n.Title = strings.Join(words[:len(words)-1], " ")
+		s = renderTemplate(template, n)
+	}
+
+	return s
+}
+
+// Rename describes a file or CD directory renamed to its canonical name
+type Rename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Game string `json:"-"`
+}
+
+type renameOptions struct {
+	template string
+	dryRun   bool
+	log      io.Writer
+}
+
+// RenameOption configures optional behaviour of Rename
+type RenameOption func(*renameOptions)
+
+// WithTemplate uses the given template to build each new name rather than
+// DefaultTemplate
+func WithTemplate(template string) RenameOption {
+	return func(o *renameOptions) {
+		o.template = template
+	}
+}
+
+// DryRun reports what would be renamed without renaming anything
+func DryRun() RenameOption {
+	return func(o *renameOptions) {
+		o.dryRun = true
+	}
+}
+
+// UndoLog writes each rename to w as it happens, which can later be passed
+// to Undo to reverse them
+func UndoLog(w io.Writer) RenameOption {
+	return func(o *renameOptions) {
+		o.log = w
+	}
+}
+
+// renameMetadata moves each screenshot in the metadata of dir from the old
+// name of a game to its new one, leaving every other entry alone. Nothing
+// is written if the directory has never been scanned
+func renameMetadata(dir string, names map[string]string) error {
+	db, err := readMetadata(dir)
+	if err != nil {
+		return err
+	}
+	if db.Length() == 0 {
+		return nil
+	}
+
+	for from, to := range names {
+		old, crc := metadata.CRCFilename(from), metadata.CRCFilename(to)
+		if old == crc {
+			continue
+		}
+		screenshot, ok := db.Get(old)
+		if !ok {
+			continue
+		}
+		db.Delete(old)
+		if err := db.Set(crc, screenshot); err != nil {
+			return err
+		}
+	}
+
+	return writeMetadata(dir, db)
+}
+
+// plannedRename is a game in a directory and the name it's to be renamed to
+type plannedRename struct {
+	game candidate
+	name string
+	r    Rename
+}
+
+// rejectCollisions removes any planned rename whose new name the firmware
+// can't tell apart from the name of another game in the same directory,
+// as they would share a single metadata entry. Rejecting one rename can
+// cause another collision so this repeats until none are left
+func (m *MegaSD) rejectCollisions(games []candidate, planned []plannedRename) []plannedRename {
+	for {
+		renamed := make(map[string]struct{})
+		for _, p := range planned {
+			renamed[p.game.path] = struct{}{}
+		}
+
+		used := make(map[uint32]string)
+		for _, g := range games {
+			if _, ok := renamed[g.path]; !ok {
+				used[metadata.CRCFilename(g.name)] = g.file()
+			}
+		}
+
+		reject := -1
+		for i, p := range planned {
+			crc := metadata.CRCFilename(p.name)
+			if other, ok := used[crc]; ok {
+				m.logger.Printf("Not renaming \"%s\", \"%s\" would share its metadata with \"%s\"\n", p.r.From, p.r.To, other)
+				reject = i
+				break
+			}
+			used[crc] = p.r.To
+		}
+		if reject < 0 {
+			return planned
+		}
+		planned = append(planned[:reject], planned[reject+1:]...)
+	}
+}
+
+func (m *MegaSD) renameDirectory(dir string, o *renameOptions) (renames []Rename, err error) {
+	games, err := candidates(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := readCueDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	msu, _ := d.msuROM()
+
+	var planned []plannedRename
+	for _, g := range games {
+		// Neither the ROM image of an MSU-MD game nor each disc of a
+		// multi-disc game can be renamed on its own
+		if !g.cd() && (g.path == msu || g.system.Layout == LayoutDirectory) {
+			continue
+		}
+
+		crc, err := g.crc()
+		if err != nil {
+			return nil, err
+		}
+		if crc == "" {
+			continue
+		}
+
+		canonical, err := m.db.canonicalName(crc, g.system.Layout == LayoutDirectory)
+		if err != nil {
+			return nil, err
+		}
+		if canonical == "" {
+			m.logger.Printf("No match for \"%s\", with CRC \"%s\"\n", g.path, crc)
+			continue
+		}
+
+		name := canonicalFilename(o.template, canonical)
+		if name == g.name || name == "" {
+			continue
+		}
+
+		from := g.file()
+		to := filepath.Join(filepath.Dir(from), name+strings.TrimPrefix(filepath.Base(from), g.name))
+
+		if _, err := os.Lstat(to); err == nil && !strings.EqualFold(from, to) {
+			m.logger.Printf("Not renaming \"%s\", \"%s\" already exists\n", from, to)
+			continue
+		}
+
+		planned = append(planned, plannedRename{g, name, Rename{From: from, To: to, Game: canonical}})
+	}
+
+	// The metadata follows every game renamed, even if a later one fails
+	names := make(map[string]string)
+	defer func() {
+		if len(names) == 0 {
+			return
+		}
+		if merr := renameMetadata(dir, names); err == nil {
+			err = merr
+		}
+	}()
+
+	for _, p := range m.rejectCollisions(games, planned) {
+		if o.dryRun {
+			m.logger.Printf("Would rename \"%s\" to \"%s\"\n", p.r.From, p.r.To)
+			renames = append(renames, p.r)
+			continue
+		}
+
+		if _, err := renameGame(p.game, p.name); err != nil {
+			return renames, err
+		}
+		m.logger.Printf("Renamed \"%s\" to \"%s\"\n", p.r.From, p.r.To)
+		renames = append(renames, p.r)
+		names[p.game.name] = p.name
+
+		if o.log != nil {
+			if err := json.NewEncoder(o.log).Encode(p.r); err != nil {
+				return renames, err
+			}
+		}
+	}
+
+	return renames, nil
+}
+
+// Rename traverses the given directory, identifies every ROM image and CD
+// directory by its checksum and renames it to its canonical name. Each
+// screenshot in the existing metadata is moved to the new name
+func (m *MegaSD) Rename(path string, opts ...RenameOption) ([]Rename, error) {
+	// The undo log shouldn't depend on the working directory
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &renameOptions{
+		template: DefaultTemplate,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	var dirs []string
+	if err := filepath.Walk(path, func(dir string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Ignore any hidden files or directories
+		if info.Name()[0] == '.' {
+			if info.Mode().IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.Mode().IsDir() {
+			dirs = append(dirs, dir)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	var renames []Rename
+
+	// Work from the deepest directory upwards so renaming a CD directory
+	// doesn't invalidate any path still to be renamed
+	for i := len(dirs) - 1; i >= 0; i-- {
+		r, err := m.renameDirectory(dirs[i], o)
+		renames = append(renames, r...)
+		if err != nil {
+			return renames, err
+		}
+	}
+
+	return renames, nil
+}
+
+// Undo reverses the renames read from an undo log written by Rename and
+// moves each screenshot in the metadata back to the original name
+func (m *MegaSD) Undo(r io.Reader) error {
+	var renames []Rename
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		var rename Rename
+		if err := json.Unmarshal(s.Bytes(), &rename); err != nil {
+			return err
+		}
+		renames = append(renames, rename)
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	for i := len(renames) - 1; i >= 0; i-- {
+		rename := renames[i]
+		if _, err := os.Lstat(rename.From); err == nil && !strings.EqualFold(rename.From, rename.To) {
+			return fmt.Errorf("unable to undo, \"%s\" already exists", rename.From)
+		}
+		info, err := os.Stat(rename.To)
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(rename.To, rename.From); err != nil {
+			return err
+		}
+		m.logger.Printf("Renamed \"%s\" back to \"%s\"\n", rename.To, rename.From)
+
+		// A CD directory is listed under its full name
+		from, to := filepath.Base(rename.From), filepath.Base(rename.To)
+		if !info.IsDir() {
+			from, to = strings.TrimSuffix(from, filepath.Ext(from)), strings.TrimSuffix(to, filepath.Ext(to))
+		}
+		if err := renameMetadata(filepath.Dir(rename.From), map[string]string{to: from}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
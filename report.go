@@ -0,0 +1,136 @@
+package megasd
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/bodgit/megasd/rom"
+)
+
+// Collision describes games in the same directory whose names hash to the
+// same CRC, so only the first one found would get a screenshot
+type Collision struct {
+	Dir   string
+	CRC   uint32
+	Names []string
+	// Identical is true if the names are the same once upper-cased and
+	// truncated by the firmware, otherwise the CRC values genuinely collide
+	Identical bool
+	// Renamed maps each entry in Names that was renamed to its new name
+	Renamed map[string]string
+}
+
+// Unmatched describes a ROM image or CD that didn't match anything in the
+// database
+type Unmatched struct {
+	File   string
+	CRC    string
+	Header *rom.Header // Nil if no header could be parsed
+}
+
+// SerialMatch describes a ROM image or CD whose CRC didn't match anything
+// in the database but whose serial matched a known game. These may be a
+// different revision, a hack or a bad dump so should be reviewed
+type SerialMatch struct {
+	File   string
+	CRC    string
+	Serial string
+	Region string
+	Game   string
+}
+
+// NameMatch describes a ROM image or CD whose CRC and serial didn't match
+// anything in the database but whose name is similar to a known game. These
+// should be reviewed, particularly those with a lower score
+type NameMatch struct {
+	File    string
+	CRC     string
+	Game    string
+	Score   float64 // Similarity of the names, from 0 to 1
+	Learned bool    // Whether the CRC was added to the database
+}
+
+// Disc describes the Redump form hashes of a CD
+type Disc struct {
+	File   string
+	CRC    string // CRC of the first data sector, as used by the firmware
+	Tracks []TrackHash
+}
+
+// Report summarises the outcome of a Scan
+type Report struct {
+	mu            sync.Mutex
+	Collisions    []Collision
+	SerialMatches []SerialMatch
+	NameMatches   []NameMatch
+	Unmatched     []Unmatched
+	Discs         []Disc
+	DiscLayouts   []DiscLayout
+	Large         []LargeDirectory
+}
+
+func (r *Report) addCollision(c Collision) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Collisions = append(r.Collisions, c)
+}
+
+func (r *Report) addSerialMatch(s SerialMatch) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.SerialMatches = append(r.SerialMatches, s)
+}
+
+func (r *Report) addNameMatch(n NameMatch) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.NameMatches = append(r.NameMatches, n)
+}
+
+func (r *Report) addUnmatched(u Unmatched) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Unmatched = append(r.Unmatched, u)
+}
+
+func (r *Report) addDisc(d Disc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Discs = append(r.Discs, d)
+}
+
+func (r *Report) addDiscLayout(d DiscLayout) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.DiscLayouts = append(r.DiscLayouts, d)
+}
+
+func (r *Report) addLargeDirectory(l LargeDirectory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Large = append(r.Large, l)
+}
+
+// fallbackMatchFiles returns the file of every game matched by its serial
+// or name rather than its CRC
+func (r *Report) fallbackMatchFiles() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	files := make([]string, 0, len(r.SerialMatches)+len(r.NameMatches))
+	for _, s := range r.SerialMatches {
+		files = append(files, s.File)
+	}
+	for _, n := range r.NameMatches {
+		files = append(files, n.File)
+	}
+	return files
+}
+
+func (r *Report) sort() {
+	sort.Slice(r.SerialMatches, func(i, j int) bool { return r.SerialMatches[i].File < r.SerialMatches[j].File })
+	sort.Slice(r.NameMatches, func(i, j int) bool { return r.NameMatches[i].File < r.NameMatches[j].File })
+	sort.Slice(r.Unmatched, func(i, j int) bool { return r.Unmatched[i].File < r.Unmatched[j].File })
+	sort.Slice(r.Discs, func(i, j int) bool { return r.Discs[i].File < r.Discs[j].File })
+	sort.Slice(r.Large, func(i, j int) bool { return r.Large[i].Dir < r.Large[j].Dir })
+	sort.SliceStable(r.DiscLayouts, func(i, j int) bool { return r.DiscLayouts[i].Dir < r.DiscLayouts[j].Dir })
+}
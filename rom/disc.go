@@ -0,0 +1,75 @@
+package rom
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// DiscHeader describes the header found in the first sector of a Mega CD
+// disc. The embedded Header is the same as that found in a cartridge,
+// although the checksum and ROM and RAM ranges are usually unused
+type DiscHeader struct {
+	DiscID     string
+	VolumeName string
+	SystemName string
+	// SecurityRegion is the region of the console the disc boots on, "J",
+	// "U" or "E", as given by its security code rather than the header.
+	// It's empty if the security code isn't recognised
+	SecurityRegion string
+	Header
+}
+
+var discSignature = []byte("SEGADISCSYSTEM")
+
+const discHeader = 0x200
+
+// Every security code starts with the same instructions, ending with a
+// branch over the rest of the code which is a different length for each
+// region
+var securityPrefix = []byte{0x43, 0xfa, 0x00, 0x0a, 0x4e, 0xb8, 0x03, 0x64, 0x60, 0x00}
+
+const securityCode = discHeader + 0x0c
+
+var securityRegions = map[uint16]string{
+	0x0156: "J",
+	0x057a: "U",
+	0x0564: "E",
+}
+
+// securityRegion returns the region of the security code following the
+// header in b, or an empty string if it isn't recognised
+func securityRegion(b []byte) string {
+	if len(b) < securityCode || !bytes.HasPrefix(b[discHeader:], securityPrefix) {
+		return ""
+	}
+	return securityRegions[binary.BigEndian.Uint16(b[securityCode-2:])]
+}
+
+// ParseDisc returns the header found in b which should hold the user data of
+// the first sector of a Mega CD disc
+func ParseDisc(b []byte) (*DiscHeader, error) {
+	if len(b) < discHeader || !bytes.HasPrefix(b, discSignature) {
+		return nil, ErrNoHeader
+	}
+
+	h, err := ParseHeader(b[mdOffset : mdOffset+mdHeader])
+	if err != nil {
+		return nil, err
+	}
+	h.System = SystemMegaCD
+
+	// The security code decides where the disc boots so stands in for
+	// a missing region in the header
+	region := securityRegion(b)
+	if h.Regions == "" {
+		h.Regions = region
+	}
+
+	return &DiscHeader{
+		DiscID:         trim(b[0x00:0x10]),
+		VolumeName:     trim(b[0x10:0x1b]),
+		SystemName:     trim(b[0x20:0x2b]),
+		SecurityRegion: region,
+		Header:         *h,
+	}, nil
+}
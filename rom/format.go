@@ -0,0 +1,90 @@
+package rom
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Format describes how a ROM image differs from a plain big-endian image
+type Format int
+
+// Each way a ROM image can differ from a plain image
+const (
+	FormatCopierHeader Format = 1 << iota
+	FormatInterleaved
+	FormatByteSwapped
+)
+
+var formatNames = []struct {
+	f    Format
+	name string
+}{
+	{FormatCopierHeader, "copier header"},
+	{FormatInterleaved, "interleaved"},
+	{FormatByteSwapped, "byte-swapped"},
+}
+
+func (f Format) String() string {
+	var names []string
+	for _, n := range formatNames {
+		if f&n.f != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "plain"
+	}
+	return strings.Join(names, ", ")
+}
+
+// IsByteSwapped reports whether each 16-bit word of the ROM image b has its
+// bytes swapped, as produced by some little-endian tools. Any copier header
+// should already have been removed
+func IsByteSwapped(b []byte) bool {
+	return len(b) >= mdOffset+0x10 && !hasSignature(b) && bytes.Contains(b[mdOffset:mdOffset+0x10], []byte("ESAG"))
+}
+
+// SwapBytes swaps the bytes of each 16-bit word of the ROM image b
+func SwapBytes(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i := 0; i+1 < len(b); i += 2 {
+		out[i], out[i+1] = b[i+1], b[i]
+	}
+	if len(b)%2 != 0 {
+		out[len(b)-1] = b[len(b)-1]
+	}
+	return out
+}
+
+// Detect works out how the ROM image b differs from a plain image
+func Detect(b []byte) Format {
+	var f Format
+	if len(b)&0xfff == CopierHeaderSize {
+		f |= FormatCopierHeader
+		b = b[CopierHeaderSize:]
+	}
+	switch {
+	case IsInterleaved(b):
+		f |= FormatInterleaved
+	case IsByteSwapped(b):
+		f |= FormatByteSwapped
+	}
+	return f
+}
+
+// Normalize converts the ROM image b into a plain big-endian image by
+// removing any copier header, deinterleaving and fixing any byte-swapping.
+// It returns the plain image and what was fixed
+func Normalize(b []byte) ([]byte, Format) {
+	f := Detect(b)
+	if f&FormatCopierHeader != 0 {
+		b = b[CopierHeaderSize:]
+	}
+	if f&FormatInterleaved != 0 {
+		b = Deinterleave(b)
+	}
+	if f&FormatByteSwapped != 0 {
+		b = SwapBytes(b)
+	}
+	return b, f
+}
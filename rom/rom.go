@@ -0,0 +1,293 @@
+/*
+Package rom parses the headers found in Mega Drive, 32X, Master System and
+SG-1000 ROM images, and Mega CD discs.
+
+The Mega Drive and 32X store a header at offset 0x100 that describes the game
+and includes a checksum of the rest of the ROM. The Master System and some
+later SG-1000 games instead store a smaller header ending in "TMR SEGA" near
+the end of the first 8, 16 or 32 KB of the ROM. A Mega CD disc starts with its own
+header which includes a copy of the Mega Drive header.
+*/
+package rom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+// System identifies the console a ROM image is intended for
+type System int
+
+// Supported systems
+const (
+	SystemUnknown System = iota
+	SystemMegaDrive
+	System32X
+	SystemMasterSystem
+	SystemGameGear
+	SystemMegaCD
+	SystemSG1000
+)
+
+func (s System) String() string {
+	switch s {
+	case SystemMegaDrive:
+		return "Mega Drive"
+	case System32X:
+		return "32X"
+	case SystemMasterSystem:
+		return "Master System"
+	case SystemGameGear:
+		return "Game Gear"
+	case SystemMegaCD:
+		return "Mega CD"
+	case SystemSG1000:
+		return "SG-1000"
+	}
+	return "Unknown"
+}
+
+// Header describes the information in a ROM header. Fields that aren't
+// present for a particular system are left as the zero value
+type Header struct {
+	System        System
+	Console       string
+	Copyright     string
+	Date          time.Time // Only the year and month are known
+	DomesticTitle string
+	OverseasTitle string
+	Serial        string
+	Version       int
+	Regions       string
+	ROMStart      uint32
+	ROMEnd        uint32
+	RAMStart      uint32
+	RAMEnd        uint32
+	Checksum      uint16
+
+	// Size of the ROM according to the header and the location of the
+	// header, both of which determine the range covered by the Master
+	// System checksum
+	size   int
+	offset int
+}
+
+// ErrNoHeader is returned when no recognisable header is found
+var ErrNoHeader = errors.New("rom: no header found")
+
+const (
+	mdOffset  = 0x100
+	mdHeader  = 0x100
+	smsHeader = 0x10
+)
+
+var smsSignature = []byte("TMR SEGA")
+
+// Parse returns the header found in the ROM image b. Any copier header
+// should already have been removed
+func Parse(b []byte) (*Header, error) {
+	if h, err := parseMegaDrive(b); err != ErrNoHeader {
+		return h, err
+	}
+	return parseMasterSystem(b)
+}
+
+// ProductCode returns the serial stripped of any product type prefix and
+// revision suffix, so that every revision of a game shares the same code. An
+// empty string is returned if the serial is blank or all zeroes
+func (h *Header) ProductCode() string {
+	serial := h.Serial
+
+	// Remove the revision, such as "-00"
+	if i := strings.LastIndexByte(serial, '-'); i >= 0 && i >= len(serial)-3 {
+		serial = serial[:i]
+	}
+
+	// Remove the product type, such as "GM" for game or "AI" for
+	// educational, which is always two characters
+	if f := strings.Fields(serial); len(f) > 1 && len(f[0]) == 2 {
+		serial = strings.Join(f[1:], " ")
+	}
+
+	// Homebrew and unlicensed games often leave the serial blank
+	if strings.Trim(serial, " -0") == "" {
+		return ""
+	}
+
+	return strings.Trim(serial, " -")
+}
+
+// Verify reports whether the checksum in the header matches the contents of
+// the ROM image b
+func (h *Header) Verify(b []byte) bool {
+	return h.ComputeChecksum(b) == h.Checksum
+}
+
+// ComputeChecksum calculates the checksum of the ROM image b the same way
+// as the checksum stored in the header
+func (h *Header) ComputeChecksum(b []byte) uint16 {
+	switch h.System {
+	case SystemMegaDrive, System32X:
+		return megaDriveChecksum(b)
+	case SystemMasterSystem, SystemGameGear:
+		return masterSystemChecksum(b, h.size, h.offset)
+	}
+	return 0
+}
+
+// trim collapses any runs of whitespace or padding into a single space
+func trim(b []byte) string {
+	return strings.Join(strings.FieldsFunc(string(b), func(r rune) bool {
+		return unicode.IsSpace(r) || r == 0
+	}), " ")
+}
+
+// ParseHeader parses the 256 byte Mega Drive header common to both
+// cartridges and the Mega CD, found at offset 0x100
+func ParseHeader(b []byte) (*Header, error) {
+	if len(b) < mdHeader || !bytes.Contains(b[:0x10], []byte("SEGA")) {
+		return nil, ErrNoHeader
+	}
+
+	h := &Header{
+		System:        SystemMegaDrive,
+		Console:       trim(b[0x00:0x10]),
+		Copyright:     trim(b[0x10:0x20]),
+		DomesticTitle: trim(b[0x20:0x50]),
+		OverseasTitle: trim(b[0x50:0x80]),
+		Serial:        trim(b[0x80:0x8e]),
+		Checksum:      binary.BigEndian.Uint16(b[0x8e:0x90]),
+		ROMStart:      binary.BigEndian.Uint32(b[0xa0:0xa4]),
+		ROMEnd:        binary.BigEndian.Uint32(b[0xa4:0xa8]),
+		RAMStart:      binary.BigEndian.Uint32(b[0xa8:0xac]),
+		RAMEnd:        binary.BigEndian.Uint32(b[0xac:0xb0]),
+		Regions:       trim(b[0xf0:0xf3]),
+	}
+
+	if strings.Contains(h.Console, "32X") {
+		h.System = System32X
+	}
+
+	// The domestic title is usually encoded as Shift-JIS
+	if title, err := japanese.ShiftJIS.NewDecoder().Bytes(b[0x20:0x50]); err == nil {
+		h.DomesticTitle = trim(title)
+	}
+
+	h.Date = parseDate(h.Copyright)
+
+	// The serial ends with a two digit revision
+	if i := strings.LastIndexByte(h.Serial, '-'); i >= 0 {
+		fmt.Sscanf(h.Serial[i+1:], "%d", &h.Version)
+	}
+
+	return h, nil
+}
+
+func parseMegaDrive(b []byte) (*Header, error) {
+	if len(b) < mdOffset+mdHeader {
+		return nil, ErrNoHeader
+	}
+	return ParseHeader(b[mdOffset : mdOffset+mdHeader])
+}
+
+var dateRegexp = regexp.MustCompile(`(\d{4})[. ]?([A-Za-z]{3})`)
+
+func parseDate(s string) time.Time {
+	m := dateRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return time.Time{}
+	}
+	t, err := time.Parse("2006 Jan", m[1]+" "+strings.ToUpper(m[2][:1])+strings.ToLower(m[2][1:]))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func megaDriveChecksum(b []byte) uint16 {
+	var sum uint16
+	for i := 0x200; i < len(b); i += 2 {
+		w := uint16(b[i]) << 8
+		if i+1 < len(b) {
+			w |= uint16(b[i+1])
+		}
+		sum += w
+	}
+	return sum
+}
+
+func bcd(b byte) int {
+	return int(b>>4)*10 + int(b&0x0f)
+}
+
+var smsSizes = map[byte]int{
+	0xa: 8 << 10,
+	0xb: 16 << 10,
+	0xc: 32 << 10,
+	0xd: 48 << 10,
+	0xe: 64 << 10,
+	0xf: 128 << 10,
+	0x0: 256 << 10,
+	0x1: 512 << 10,
+	0x2: 1 << 20,
+}
+
+func parseMasterSystem(b []byte) (*Header, error) {
+	for _, offset := range []int{0x7ff0, 0x3ff0, 0x1ff0} {
+		if len(b) < offset+smsHeader || !bytes.Equal(b[offset:offset+len(smsSignature)], smsSignature) {
+			continue
+		}
+		hdr := b[offset : offset+smsHeader]
+
+		h := &Header{
+			System:   SystemMasterSystem,
+			Console:  string(smsSignature),
+			Checksum: binary.LittleEndian.Uint16(hdr[0xa:0xc]),
+			Serial:   fmt.Sprintf("%d", int(hdr[0xe]>>4)*10000+bcd(hdr[0xd])*100+bcd(hdr[0xc])),
+			Version:  int(hdr[0xe] & 0x0f),
+			size:     smsSizes[hdr[0xf]&0x0f],
+			offset:   offset,
+		}
+
+		switch hdr[0xf] >> 4 {
+		case 3:
+			h.Regions = "Japan"
+		case 4:
+			h.Regions = "Export"
+		case 5:
+			h.System, h.Regions = SystemGameGear, "Japan"
+		case 6:
+			h.System, h.Regions = SystemGameGear, "Export"
+		case 7:
+			h.System, h.Regions = SystemGameGear, "International"
+		}
+
+		return h, nil
+	}
+
+	return nil, ErrNoHeader
+}
+
+func masterSystemChecksum(b []byte, size, offset int) uint16 {
+	if size == 0 || size > len(b) {
+		size = len(b)
+	}
+
+	var sum uint16
+	for i := 0; i < size && i < offset; i++ {
+		sum += uint16(b[i])
+	}
+	// Anything after the first 32 KB is also included
+	for i := 0x8000; i < size; i++ {
+		sum += uint16(b[i])
+	}
+	return sum
+}
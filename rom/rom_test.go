@@ -0,0 +1,178 @@
+package rom
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMegaDrive(t *testing.T) {
+	b := make([]byte, 0x400)
+	copy(b[0x100:], "SEGA MEGA DRIVE (C)SEGA 1991.JUN")
+	copy(b[0x120:], "\x83\x5c\x83\x6a\x83\x62\x83\x4e")
+	copy(b[0x150:], "SONIC THE               HEDGEHOG")
+	copy(b[0x180:], "GM 00001009-01")
+	binary.BigEndian.PutUint32(b[0x1a4:], 0x3ff)
+	copy(b[0x1f0:], "JUE")
+	for i := 0x200; i < len(b); i++ {
+		b[i] = byte(i)
+	}
+
+	h, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, SystemMegaDrive, h.System)
+	assert.Equal(t, "SEGA MEGA DRIVE", h.Console)
+	assert.Equal(t, time.Date(1991, time.June, 1, 0, 0, 0, 0, time.UTC), h.Date)
+	assert.Equal(t, "ソニック", h.DomesticTitle)
+	assert.Equal(t, "SONIC THE HEDGEHOG", h.OverseasTitle)
+	assert.Equal(t, "GM 00001009-01", h.Serial)
+	assert.Equal(t, "00001009", h.ProductCode())
+	assert.Equal(t, 1, h.Version)
+	assert.Equal(t, uint32(0x3ff), h.ROMEnd)
+	assert.Equal(t, "JUE", h.Regions)
+
+	assert.False(t, h.Verify(b))
+	binary.BigEndian.PutUint16(b[0x18e:], h.ComputeChecksum(b))
+	h, _ = Parse(b)
+	assert.True(t, h.Verify(b))
+}
+
+func TestMasterSystem(t *testing.T) {
+	b := make([]byte, 0x8000)
+	for i := range b {
+		b[i] = 1
+	}
+	copy(b[0x7ff0:], "TMR SEGA")
+	copy(b[0x7ffc:], []byte{0x06, 0x70, 0x10, 0x4c})
+
+	h, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, SystemMasterSystem, h.System)
+	assert.Equal(t, "17006", h.Serial)
+	assert.Equal(t, "17006", h.ProductCode())
+	assert.Equal(t, 0, h.Version)
+	assert.Equal(t, "Export", h.Regions)
+	assert.Equal(t, uint16(0x7ff0), h.ComputeChecksum(b))
+}
+
+func TestNoHeader(t *testing.T) {
+	_, err := Parse(make([]byte, 0x8000))
+	assert.Equal(t, ErrNoHeader, err)
+}
+
+func TestDisc(t *testing.T) {
+	b := make([]byte, 2048)
+	copy(b, "SEGADISCSYSTEM  SONICCD    ")
+	copy(b[0x20:], "SYSTEM")
+	copy(b[0x100:], "SEGA MEGA DRIVE (C)SEGA 1993.OCT")
+	copy(b[0x180:], "GM MK-4407 -00")
+	copy(b[0x1f0:], "U")
+
+	h, err := ParseDisc(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, SystemMegaCD, h.System)
+	assert.Equal(t, "SEGADISCSYSTEM", h.DiscID)
+	assert.Equal(t, "SONICCD", h.VolumeName)
+	assert.Equal(t, "SYSTEM", h.SystemName)
+	assert.Equal(t, "GM MK-4407 -00", h.Serial)
+	assert.Equal(t, "MK-4407", h.ProductCode())
+	assert.Equal(t, time.Date(1993, time.October, 1, 0, 0, 0, 0, time.UTC), h.Date)
+	assert.Equal(t, "U", h.Regions)
+
+	_, err = ParseDisc(make([]byte, 2048))
+	assert.Equal(t, ErrNoHeader, err)
+}
+
+func TestDiscSecurityRegion(t *testing.T) {
+	tables := []struct {
+		file   string
+		region string
+	}{
+		{"disc-jpn.bin", "J"},
+		{"disc-usa.bin", "U"},
+		{"disc-eur.bin", "E"},
+	}
+
+	for _, table := range tables {
+		b, err := ioutil.ReadFile(filepath.Join("testdata", table.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h, err := ParseDisc(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, table.region, h.SecurityRegion, table.file)
+	}
+
+	// No security code at all
+	b := make([]byte, 2048)
+	copy(b, "SEGADISCSYSTEM")
+	copy(b[0x100:], "SEGA MEGA DRIVE")
+	h, err := ParseDisc(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "", h.SecurityRegion)
+}
+
+func TestInterleave(t *testing.T) {
+	b := make([]byte, 2*smdBlock)
+	copy(b[0x100:], "SEGA MEGA DRIVE")
+	for i := 0x200; i < len(b); i++ {
+		b[i] = byte(i * 7)
+	}
+
+	// Interleave the plain image by hand
+	smd := make([]byte, len(b))
+	for i := 0; i < len(b); i += smdBlock {
+		for j := 0; j < smdBlock/2; j++ {
+			smd[i+j] = b[i+j<<1+1]
+			smd[i+smdBlock/2+j] = b[i+j<<1]
+		}
+	}
+
+	assert.False(t, IsInterleaved(b))
+	assert.True(t, IsInterleaved(smd))
+	assert.Equal(t, b, Deinterleave(smd))
+}
+
+func TestTrimOverdump(t *testing.T) {
+	b := make([]byte, 4*smdBlock)
+	for i := range b[:smdBlock] {
+		b[i] = byte(i)
+	}
+	copy(b[smdBlock:], b[:smdBlock])
+
+	assert.Equal(t, b[:smdBlock], TrimOverdump(b))
+	assert.Len(t, TrimOverdump(b[:smdBlock]), smdBlock)
+}
+
+func TestNormalize(t *testing.T) {
+	b := make([]byte, smdBlock)
+	copy(b[0x100:], "SEGA MEGA DRIVE")
+
+	n, f := Normalize(b)
+	assert.Equal(t, Format(0), f)
+	assert.Equal(t, b, n)
+
+	header := make([]byte, CopierHeaderSize)
+	n, f = Normalize(append(header, SwapBytes(b)...))
+	assert.Equal(t, FormatCopierHeader|FormatByteSwapped, f)
+	assert.Equal(t, "copier header, byte-swapped", f.String())
+	assert.Equal(t, b, n)
+}
@@ -0,0 +1,77 @@
+package rom
+
+import "bytes"
+
+const (
+	// CopierHeaderSize is the size of the header prepended to a ROM image
+	// by copiers such as the Super Magic Drive
+	CopierHeaderSize = 512
+
+	smdBlock = 0x4000
+)
+
+// HasSMDHeader reports whether b starts with a Super Magic Drive copier
+// header
+func HasSMDHeader(b []byte) bool {
+	return len(b) >= CopierHeaderSize && b[8] == 0xaa && b[9] == 0xbb
+}
+
+func deinterleaveBlock(dst, src []byte) {
+	half := len(src) / 2
+	for i := 0; i < half; i++ {
+		dst[i<<1] = src[half+i]
+		dst[i<<1+1] = src[i]
+	}
+}
+
+func hasSignature(b []byte) bool {
+	return len(b) >= mdOffset+0x10 && bytes.Contains(b[mdOffset:mdOffset+0x10], []byte("SEGA"))
+}
+
+// IsInterleaved reports whether the ROM image b is stored in the interleaved
+// format used by the Super Magic Drive. Any copier header should already
+// have been removed
+func IsInterleaved(b []byte) bool {
+	if len(b) == 0 || len(b)%smdBlock != 0 || hasSignature(b) {
+		return false
+	}
+
+	block := make([]byte, smdBlock)
+	deinterleaveBlock(block, b[:smdBlock])
+
+	return hasSignature(block)
+}
+
+// Deinterleave converts a ROM image from the interleaved Super Magic Drive
+// format, where each 16 KB block stores the odd bytes followed by the even
+// bytes, into a plain image
+func Deinterleave(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i := 0; i+smdBlock <= len(b); i += smdBlock {
+		deinterleaveBlock(out[i:i+smdBlock], b[i:i+smdBlock])
+	}
+	return out
+}
+
+func isFill(b []byte) bool {
+	for _, c := range b[1:] {
+		if c != b[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// TrimOverdump returns the ROM image b with any overdumped data removed.
+// While the second half of the image is either a mirror of the first half
+// or is filled with the same byte, it is discarded
+func TrimOverdump(b []byte) []byte {
+	for len(b) > smdBlock && len(b)%2 == 0 {
+		half := len(b) / 2
+		if !bytes.Equal(b[:half], b[half:]) && !isFill(b[half:]) {
+			break
+		}
+		b = b[:half]
+	}
+	return b
+}
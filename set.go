@@ -0,0 +1,118 @@
+package megasd
+
+import (
+	"bytes"
+	"errors"
+	img "image"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bodgit/megasd/image"
+	"github.com/bodgit/megasd/metadata"
+)
+
+// gameName returns the directory containing the metadata database that
+// describes the given ROM image, cue sheet or CD directory, along with the
+// name of the game as hashed by the firmware
+func gameName(file string) (string, string, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch {
+	case info.IsDir():
+		file = filepath.Clean(file)
+	case inDirectory(file):
+		file = filepath.Dir(file)
+	default:
+		return filepath.Dir(file), strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)), nil
+	}
+
+	return filepath.Dir(file), filepath.Base(file), nil
+}
+
+// SetScreenshot stores a screenshot, genre and year for the given ROM image
+// or CD directory in the metadata database alongside it, replacing any
+// existing entry. An empty genre or zero year is treated as unknown. If m is
+// nil then the existing screenshot is kept and only a non-empty genre or
+// non-zero year is updated
+func SetScreenshot(file string, m img.Image, genre string, year int) error {
+	dir, name, err := gameName(file)
+	if err != nil {
+		return err
+	}
+
+	var g int
+	if genre != "" {
+		parsed, err := parseGenre(genre)
+		if err != nil {
+			return err
+		}
+		g = int(parsed)
+	}
+
+	db, err := readMetadata(dir)
+	if err != nil {
+		return err
+	}
+
+	crc := metadata.CRCFilename(name)
+	screenshot := make([]byte, metadata.ScreenshotSize)
+
+	if m != nil {
+		b := new(bytes.Buffer)
+		if err := image.Encode(b, m); err != nil {
+			return err
+		}
+		copy(screenshot, b.Bytes())
+	} else {
+		old, ok := db.Get(crc)
+		if !ok {
+			return errors.New("no existing screenshot")
+		}
+		copy(screenshot, old)
+
+		oldGenre, oldYear, _ := metadata.Info(old)
+		if genre == "" {
+			g = oldGenre
+		}
+		if year == 0 {
+			year = oldYear
+		}
+	}
+
+	metadata.SetInfo(screenshot, g, year)
+
+	db.Delete(crc)
+	if err := db.Set(crc, screenshot); err != nil {
+		return err
+	}
+
+	return writeMetadata(dir, db)
+}
+
+// RemoveScreenshot removes the entry for the given ROM image or CD directory
+// from the metadata database alongside it
+func RemoveScreenshot(file string) error {
+	dir, name, err := gameName(file)
+	if err != nil {
+		return err
+	}
+
+	db, err := readMetadata(dir)
+	if err != nil {
+		return err
+	}
+
+	if !db.Delete(metadata.CRCFilename(name)) {
+		return errors.New("no existing screenshot")
+	}
+
+	if db.Length() == 0 {
+		return os.Remove(filepath.Join(dir, metadata.Filename))
+	}
+
+	return writeMetadata(dir, db)
+}
@@ -0,0 +1,215 @@
+package megasd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bodgit/megasd/rom"
+)
+
+// Layout describes where the files for a game live relative to the
+// directory whose metadata describes it
+type Layout int
+
+const (
+	// LayoutFile is a single file directly inside the directory, such as
+	// a ROM image. The game is named after the file without its extension
+	LayoutFile Layout = iota
+	// LayoutDirectory is a file inside its own sub-directory, such as the
+	// cue sheet of a CD. The game is named after the sub-directory
+	LayoutDirectory
+)
+
+// System describes how the games for a system are stored and identified
+type System struct {
+	System rom.System
+	// Extensions are the file extensions, including the leading ".",
+	// which are matched case-insensitively
+	Extensions []string
+	// Hash returns the CRC used to look up the game in the database
+	Hash func(file string) (string, error)
+	// Header returns the parsed header of the game
+	Header func(file string) (*rom.Header, error)
+	// MaxSize is the largest file that will be considered, zero means no
+	// limit
+	MaxSize int64
+	Layout  Layout
+}
+
+// Registry maps file extensions to systems. It's safe for concurrent use,
+// although any system should be registered before a scan starts so every
+// file is looked up against the same systems
+type Registry struct {
+	mu         sync.RWMutex
+	systems    map[rom.System]*System
+	extensions map[string]*System
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		systems:    make(map[rom.System]*System),
+		extensions: make(map[string]*System),
+	}
+}
+
+// Register adds a system along with its extensions, replacing any system
+// previously registered as the same rom.System. It is an error if any
+// extension already belongs to a different system
+func (r *Registry) Register(s System) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, ext := range s.Extensions {
+		if other, ok := r.extensions[strings.ToLower(ext)]; ok && other.System != s.System {
+			return fmt.Errorf("extension \"%s\" is already registered to %s", ext, other.System)
+		}
+	}
+
+	if old, ok := r.systems[s.System]; ok {
+		for _, ext := range old.Extensions {
+			delete(r.extensions, strings.ToLower(ext))
+		}
+	}
+
+	n := s
+	n.Extensions = append([]string(nil), s.Extensions...)
+	r.systems[s.System] = &n
+	for _, ext := range n.Extensions {
+		r.extensions[strings.ToLower(ext)] = &n
+	}
+
+	return nil
+}
+
+// RegisterExtension adds an extra extension to an already registered
+// system, such as ".gen" for the Mega Drive
+func (r *Registry) RegisterExtension(system rom.System, ext string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.systems[system]
+	if !ok {
+		return fmt.Errorf("%s is not registered", system)
+	}
+
+	if other, ok := r.extensions[strings.ToLower(ext)]; ok {
+		if other == s {
+			return nil
+		}
+		return fmt.Errorf("extension \"%s\" is already registered to %s", ext, other.System)
+	}
+
+	// Replace rather than modify the system as Lookup may have already
+	// handed it out
+	n := *s
+	n.Extensions = append(append([]string(nil), s.Extensions...), ext)
+	r.systems[system] = &n
+	for _, e := range n.Extensions {
+		r.extensions[strings.ToLower(e)] = &n
+	}
+
+	return nil
+}
+
+// Lookup returns the system for the given file based on its extension, or
+// nil if there isn't one. The system must not be modified, a later
+// registration replaces it rather than changing it
+func (r *Registry) Lookup(file string) *System {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.extensions[strings.ToLower(filepath.Ext(file))]
+}
+
+// Extensions returns every registered extension in lower case
+func (r *Registry) Extensions() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var exts []string
+	for ext := range r.extensions {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+
+	return exts
+}
+
+// DefaultRegistry is the registry used to find games. It contains the
+// systems supported by the MegaSD with the extensions the firmware
+// recognises. Anything else should be registered before calling Scan
+var DefaultRegistry = NewRegistry()
+
+func init() {
+	for _, s := range []System{
+		{
+			System:     rom.SystemMegaDrive,
+			Extensions: []string{".md", ".bin"},
+			Hash:       crcFile,
+			Header:     romHeader,
+			MaxSize:    16 << (10 * 2),
+			Layout:     LayoutFile,
+		},
+		{
+			System:     rom.System32X,
+			Extensions: []string{".32x"},
+			Hash:       crcFile,
+			Header:     romHeader,
+			MaxSize:    16 << (10 * 2),
+			Layout:     LayoutFile,
+		},
+		{
+			System:     rom.SystemMasterSystem,
+			Extensions: []string{".sms"},
+			Hash:       crcFile,
+			Header:     romHeader,
+			MaxSize:    4 << (10 * 2),
+			Layout:     LayoutFile,
+		},
+		{
+			System:     rom.SystemSG1000,
+			Extensions: []string{".sg"},
+			Hash:       crcFile,
+			Header:     romHeader,
+			MaxSize:    1 << (10 * 2),
+			Layout:     LayoutFile,
+		},
+		{
+			System:     rom.SystemMegaCD,
+			Extensions: []string{".cue", ".iso"},
+			Hash:       crcDiscFile,
+			Header:     discHeader,
+			Layout:     LayoutDirectory,
+		},
+	} {
+		if err := DefaultRegistry.Register(s); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// lookupSystem returns the system for the given file from DefaultRegistry
+func lookupSystem(file string) *System {
+	return DefaultRegistry.Lookup(file)
+}
+
+// inDirectory reports whether the file belongs to a system that keeps each
+// game in its own directory
+func inDirectory(file string) bool {
+	s := lookupSystem(file)
+	return s != nil && s.Layout == LayoutDirectory
+}
+
+// hasExt reports whether the file has the given extension, ignoring case
+func hasExt(file, ext string) bool {
+	return strings.EqualFold(filepath.Ext(file), ext)
+}
+
+// isCue reports whether the file is a cue sheet
+func isCue(file string) bool {
+	return hasExt(file, ".cue")
+}
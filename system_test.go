@@ -0,0 +1,53 @@
+package megasd
+
+import (
+	"testing"
+
+	"github.com/bodgit/megasd/rom"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	assert.Nil(t, r.Lookup("game.md"))
+
+	assert.Nil(t, r.Register(System{System: rom.SystemMegaDrive, Extensions: []string{".md", ".bin"}}))
+	assert.NotNil(t, r.Register(System{System: rom.System32X, Extensions: []string{".BIN"}}))
+
+	s := r.Lookup("GAME.MD")
+	if assert.NotNil(t, s) {
+		assert.Equal(t, rom.SystemMegaDrive, s.System)
+	}
+
+	assert.NotNil(t, r.RegisterExtension(rom.SystemMasterSystem, ".sms"))
+	assert.Nil(t, r.RegisterExtension(rom.SystemMegaDrive, ".Gen"))
+	if gen := r.Lookup("game.gen"); assert.NotNil(t, gen) {
+		assert.Equal(t, rom.SystemMegaDrive, gen.System)
+		assert.Equal(t, []string{".md", ".bin", ".Gen"}, gen.Extensions)
+	}
+	// A system already looked up is left alone
+	assert.Equal(t, []string{".md", ".bin"}, s.Extensions)
+	assert.Equal(t, []string{".bin", ".gen", ".md"}, r.Extensions())
+}
+
+func TestRegistryConcurrent(t *testing.T) {
+	r := NewRegistry()
+	assert.Nil(t, r.Register(System{System: rom.SystemMegaDrive, Extensions: []string{".md"}}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if s := r.Lookup("game.md"); s != nil {
+				_ = len(s.Extensions)
+			}
+		}
+	}()
+
+	for _, ext := range []string{".bin", ".gen", ".smd"} {
+		assert.Nil(t, r.RegisterExtension(rom.SystemMegaDrive, ext))
+	}
+	<-done
+
+	assert.Equal(t, []string{".bin", ".gen", ".md", ".smd"}, r.Extensions())
+}
@@ -0,0 +1,5 @@
+FILE "game.bin" BINARY
+  TRACK 01 MODE1/2352
+    INDEX 01 00:00:00
+  TRACK 02 MODE2/2352
+    INDEX 01 00:00:01
@@ -0,0 +1,3 @@
+FILE "Sonic MSU-01.wav" WAVE
+  TRACK 01 AUDIO
+    INDEX 01 00:00:00
@@ -0,0 +1,8 @@
+FILE "disc.bin" BINARY
+  TRACK 01 MODE1/2352
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    INDEX 01 00:00:02
+  TRACK 03 MODE1/2352
+    INDEX 00 00:00:03
+    INDEX 01 00:00:05
@@ -0,0 +1,248 @@
+package megasd
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/bodgit/megasd/rom"
+)
+
+// DumpClass classifies a problem, or lack of one, found with a ROM image
+type DumpClass int
+
+// Each class of problem found by VerifyROMs
+const (
+	DumpGood DumpClass = iota
+	DumpUnknown
+	DumpOverdump
+	DumpCopierHeader
+	DumpChecksumMismatch
+	DumpInterleaved
+	DumpByteSwapped
+)
+
+var dumpClassNames = map[DumpClass]string{
+	DumpGood:             "Good",
+	DumpUnknown:          "Bad or unknown",
+	DumpOverdump:         "Overdump",
+	DumpCopierHeader:     "Copier header",
+	DumpChecksumMismatch: "Checksum mismatch",
+	DumpInterleaved:      "SMD interleaved",
+	DumpByteSwapped:      "Byte-swapped",
+}
+
+func (c DumpClass) String() string {
+	return dumpClassNames[c]
+}
+
+// Finding is a single class of problem found with a ROM image along with an
+// explanation
+type Finding struct {
+	Class       DumpClass
+	Explanation string
+}
+
+// Verification is the outcome of verifying a single ROM image
+type Verification struct {
+	File string
+	CRC  string // CRC of the whole file
+	// Name is the name of the DAT entry the ROM image matches, possibly
+	// only once any problems are fixed
+	Name     string
+	Findings []Finding
+}
+
+// Good reports whether the ROM image matches a DAT entry as-is
+func (v *Verification) Good() bool {
+	return len(v.Findings) == 1 && v.Findings[0].Class == DumpGood
+}
+
+func (v *Verification) add(class DumpClass, format string, a ...interface{}) {
+	v.Findings = append(v.Findings, Finding{class, fmt.Sprintf(format, a...)})
+}
+
+func crcBytes(b []byte) string {
+	return fmt.Sprintf("%.*X", crc32.Size<<1, crc32.ChecksumIEEE(b))
+}
+
+// findDAT returns the name of the cartridge DAT entry with the given CRC and
+// size, or an empty string if there isn't one
+func (db *gameDB) findDAT(crc string, size int) (string, error) {
+	var name string
+	switch err := db.db.QueryRow("SELECT name FROM dat WHERE crc = ? AND size = ? AND system != ? ORDER BY name LIMIT 1", crc, size, rom.SystemMegaCD.String()).Scan(&name); err {
+	case nil, sql.ErrNoRows:
+		return name, nil
+	default:
+		return "", err
+	}
+}
+
+func (m *MegaSD) verifyROM(file string) (*Verification, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Verification{
+		File: file,
+		CRC:  crcBytes(b),
+	}
+
+	if v.Name, err = m.db.findDAT(v.CRC, len(b)); err != nil {
+		return nil, err
+	}
+	if v.Name != "" {
+		v.add(DumpGood, "matches \"%s\"", v.Name)
+		return v, nil
+	}
+
+	// The MegaSD and crcFile both skip anything that isn't a multiple of
+	// 4 KB, assuming it's a copier header
+	if n := len(b) & 0xfff; n != 0 {
+		switch {
+		case n == rom.CopierHeaderSize && rom.HasSMDHeader(b):
+			v.add(DumpCopierHeader, "starts with a %d byte Super Magic Drive header, which should be removed", n)
+		case n == rom.CopierHeaderSize:
+			v.add(DumpCopierHeader, "starts with a %d byte copier header, which should be removed", n)
+		default:
+			v.add(DumpCopierHeader, "size isn't a multiple of 4 KB so the first %d bytes are skipped as if they were a copier header; this is more likely a hack or a truncated dump", n)
+		}
+		b = b[n:]
+	}
+
+	switch {
+	case rom.IsInterleaved(b):
+		v.add(DumpInterleaved, "stored in the Super Magic Drive interleaved format, which needs converting to a plain image")
+		b = rom.Deinterleave(b)
+	case rom.IsByteSwapped(b):
+		v.add(DumpByteSwapped, "the bytes of each word are swapped, which needs converting to a plain image")
+		b = rom.SwapBytes(b)
+	}
+
+	if trimmed := rom.TrimOverdump(b); len(trimmed) < len(b) {
+		if v.Name, err = m.db.findDAT(crcBytes(trimmed), len(trimmed)); err != nil {
+			return nil, err
+		}
+		// The padding may be genuine so compare the untrimmed image too,
+		// in which case it's not an overdump at all
+		genuine := false
+		if v.Name == "" {
+			if v.Name, err = m.db.findDAT(crcBytes(b), len(b)); err != nil {
+				return nil, err
+			}
+			genuine = v.Name != ""
+		}
+		if !genuine {
+			v.add(DumpOverdump, "the last %d bytes are a mirror or padding of the first %d bytes", len(b)-len(trimmed), len(trimmed))
+			if v.Name != "" {
+				b = trimmed
+			}
+		}
+	} else if len(v.Findings) > 0 {
+		if v.Name, err = m.db.findDAT(crcBytes(b), len(b)); err != nil {
+			return nil, err
+		}
+	}
+
+	if h, err := rom.Parse(b); err == nil && hasChecksum(h) && !h.Verify(b) {
+		if v.Name != "" {
+			v.add(DumpChecksumMismatch, "the header checksum is %04X but the contents add up to %04X, as they do in the original release", h.Checksum, h.ComputeChecksum(b))
+		} else {
+			v.add(DumpChecksumMismatch, "the header checksum is %04X but the contents add up to %04X, which suggests a bad dump or a hack", h.Checksum, h.ComputeChecksum(b))
+		}
+	}
+
+	if v.Name != "" {
+		v.Findings = append([]Finding{{DumpGood, fmt.Sprintf("matches \"%s\" once fixed", v.Name)}}, v.Findings...)
+	} else {
+		v.add(DumpUnknown, "CRC %s doesn't match any imported DAT entry so it's either a bad dump, a hack or an unknown revision", v.CRC)
+	}
+
+	return v, nil
+}
+
+// hasChecksum reports whether the header has a checksum that can be
+// verified
+func hasChecksum(h *rom.Header) bool {
+	switch h.System {
+	case rom.SystemMegaDrive, rom.System32X, rom.SystemMasterSystem, rom.SystemGameGear:
+		return true
+	}
+	return false
+}
+
+// isVerifiable reports whether the file is a ROM image, including the
+// formats produced by copiers
+func isVerifiable(file string) bool {
+	if hasExt(file, ".gen") || hasExt(file, ".smd") {
+		return true
+	}
+	return isROM(file)
+}
+
+// findROMs returns every ROM image found under the given path, which may
+// also be a single file
+func findROMs(path string) ([]string, error) {
+	cues := newCueCache()
+	var files []string
+	if err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Ignore any hidden files or directories
+		if info.Name()[0] == '.' {
+			if info.Mode().IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.Mode().IsRegular() || !isVerifiable(file) {
+			return nil
+		}
+
+		// Any file referenced by a cue sheet is a CD track
+		d, err := cues.dir(filepath.Dir(file))
+		if err != nil {
+			return err
+		}
+		if d.isTrack(file) {
+			return nil
+		}
+
+		files = append(files, file)
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// VerifyROMs traverses the given directory and classifies every ROM image
+// against the imported DAT files and its own header, explaining any problems
+// found
+func (m *MegaSD) VerifyROMs(path string) ([]Verification, error) {
+	files, err := findROMs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Verification
+	for _, file := range files {
+		v, err := m.verifyROM(file)
+		if err != nil {
+			return nil, err
+		}
+		m.logger.Printf("Verified \"%s\", with CRC \"%s\"\n", file, v.CRC)
+		results = append(results, *v)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,84 @@
+package megasd
+
+import (
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bodgit/megasd/rom"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyROMOverdump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "megasd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := New(filepath.Join(dir, "test.db"), log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// 32 KB of data mirrored to 64 KB, behind a copier header so the
+	// image itself never matches the DAT
+	half := make([]byte, 0x8000)
+	rand.New(rand.NewSource(1)).Read(half)
+	image := append(append([]byte{}, half...), half...)
+	b := append(make([]byte, rom.CopierHeaderSize), image...)
+
+	file := filepath.Join(dir, "test.md")
+	if err := ioutil.WriteFile(file, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	tables := []struct {
+		dat      []byte
+		name     string
+		findings []DumpClass
+	}{
+		{
+			nil,
+			"",
+			[]DumpClass{DumpCopierHeader, DumpOverdump, DumpUnknown},
+		},
+		{
+			half,
+			"Trimmed",
+			[]DumpClass{DumpGood, DumpCopierHeader, DumpOverdump},
+		},
+		{
+			image,
+			"Untrimmed",
+			[]DumpClass{DumpGood, DumpCopierHeader},
+		},
+	}
+
+	for _, table := range tables {
+		if _, err := m.db.db.Exec("DELETE FROM dat"); err != nil {
+			t.Fatal(err)
+		}
+		if table.dat != nil {
+			if _, err := m.db.db.Exec("INSERT INTO dat (system, name, region, revision, file, size, crc, md5, sha1) VALUES (?, ?, '', '', '', ?, ?, '', '')", rom.SystemMegaDrive.String(), table.name, len(table.dat), crcBytes(table.dat)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		v, err := m.verifyROM(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var findings []DumpClass
+		for _, f := range v.Findings {
+			findings = append(findings, f.Class)
+		}
+		assert.Equal(t, table.name, v.Name)
+		assert.Equal(t, table.findings, findings, table.name)
+	}
+}
@@ -0,0 +1,111 @@
+package megasd
+
+import (
+	"io"
+	"os"
+
+	"github.com/bodgit/megasd/cd"
+	"github.com/bodgit/megasd/cue"
+)
+
+// DamagedSector describes a single sector that failed verification
+type DamagedSector struct {
+	LBA      int
+	Offset   int64 // Offset of the sector within its file
+	Errors   cd.Error
+	Repaired bool
+}
+
+// TrackVerification is the outcome of verifying every sector of a single
+// MODE1/2352 data track
+type TrackVerification struct {
+	File    string
+	Track   int
+	Sectors int
+	Damaged []DamagedSector
+}
+
+func verifyTrack(e trackExtent, repair bool) (*TrackVerification, error) {
+	flag := os.O_RDONLY
+	if repair {
+		flag = os.O_RDWR
+	}
+	f, err := os.OpenFile(e.file, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Any pregap stored in the file before INDEX 01 isn't part of the data
+	skip := e.track.Start() - firstIndex(e.track)
+
+	v := &TrackVerification{
+		File:    e.file,
+		Track:   e.track.Number,
+		Sectors: e.sectors() - skip,
+	}
+
+	sector := make([]byte, cd.SectorSize)
+	for i := skip; i < e.sectors(); i++ {
+		offset := e.offset + int64(i)*cd.SectorSize
+		if _, err := f.ReadAt(sector, offset); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		lba := e.lba + i
+		errs := cd.Check(sector, lba)
+		if errs == 0 {
+			continue
+		}
+
+		d := DamagedSector{
+			LBA:    lba,
+			Offset: offset,
+			Errors: errs,
+		}
+		if repair && cd.Repair(sector, lba) {
+			if _, err := f.WriteAt(sector, offset); err != nil {
+				return nil, err
+			}
+			d.Repaired = true
+		}
+		v.Damaged = append(v.Damaged, d)
+	}
+
+	if repair {
+		if err := f.Sync(); err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+// VerifyDisc checks the sync pattern, address, EDC and ECC of every sector
+// of each MODE1/2352 data track of the disc described by the cue sheet. If
+// repair is true then any damaged sector that can be repaired is rewritten
+// in place
+func VerifyDisc(file string, repair bool) ([]TrackVerification, error) {
+	extents, err := discExtents(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []TrackVerification
+	for _, e := range extents {
+		if e.track.Mode != cue.Mode1Raw {
+			continue
+		}
+
+		v, err := verifyTrack(e, repair)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, *v)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,86 @@
+package megasd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bodgit/megasd/cd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyDisc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "megasd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The disc is repaired in place so work on a copy
+	for _, file := range []string{"disc.cue", "disc.bin"} {
+		b, err := ioutil.ReadFile(filepath.Join("testdata", "verifydisc", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, file), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(dir, "disc.cue")
+
+	damaged, err := ioutil.ReadFile(filepath.Join(dir, "disc.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Track 3 has two sectors of pregap before INDEX 01 that aren't
+	// checked, the second of its data sectors has one damaged byte
+	offset := int64(6 * cd.SectorSize)
+	for _, repair := range []bool{false, true} {
+		results, err := VerifyDisc(file, repair)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !assert.Len(t, results, 2) {
+			continue
+		}
+		assert.Equal(t, 1, results[0].Track)
+		assert.Equal(t, 2, results[0].Sectors)
+		assert.Empty(t, results[0].Damaged)
+		assert.Equal(t, 3, results[1].Track)
+		assert.Equal(t, 3, results[1].Sectors)
+		if assert.Len(t, results[1].Damaged, 1) {
+			d := results[1].Damaged[0]
+			assert.Equal(t, 6, d.LBA)
+			assert.Equal(t, offset, d.Offset)
+			assert.NotZero(t, d.Errors&cd.ErrEDC)
+			assert.Equal(t, repair, d.Repaired)
+		}
+	}
+
+	// Only the damaged byte is changed
+	b, err := ioutil.ReadFile(filepath.Join(dir, "disc.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, b, len(damaged)) {
+		var changed []int64
+		for i := range b {
+			if b[i] != damaged[i] {
+				changed = append(changed, int64(i))
+			}
+		}
+		assert.Equal(t, []int64{offset + cd.HeaderSize + 100}, changed)
+		assert.Equal(t, byte(6*7+100), b[offset+cd.HeaderSize+100])
+	}
+
+	results, err := VerifyDisc(file, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range results {
+		assert.Empty(t, v.Damaged, v.Track)
+	}
+}